@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+
+	"github.com/mercierc/pauli/src"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache volumes of builder.caches.",
+	Long: "The caches of builder.caches in config.yaml are docker volumes " +
+		"kept across build container recreations. A cache belongs to the " +
+		"project, unless it is shared by name with the other projects.",
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the caches of the project and the shared caches.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		printCaches("cache ls", false)
+	},
+}
+
+var cacheSizeCmd = &cobra.Command{
+	Use:   "size",
+	Short: "List the caches with their size on disk.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		printCaches("cache size", true)
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear [name]",
+	Short: "Remove a cache, or every cache of the project.",
+	Long: "Remove the cache called name, or every cache of the project " +
+		"without name. Shared caches are only removed by name. The build " +
+		"container is removed too and recreated by the next command.",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		}
+		exit("cache clear", 0, newCacheManager("cache clear").ClearCaches(name))
+	},
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheLsCmd, cacheSizeCmd, cacheClearCmd)
+}
+
+// Container manager of the build container of the current folder.
+func newCacheManager(task string) *src.ContainerManager {
+	containerName, _ := os.Getwd()
+	containerName = filepath.Base(containerName) + "_build"
+
+	cm, err := src.NewContainerManager(src.WithName(containerName))
+	if err != nil {
+		exit(task, -1, err)
+	}
+	return cm
+}
+
+// Print the caches, with their size if withSize is set.
+func printCaches(task string, withSize bool) {
+	caches, err := newCacheManager(task).Caches(withSize)
+	if err != nil {
+		exit(task, -1, err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	var total int64
+	for _, cache := range caches {
+		scope := "project"
+		if cache.Shared {
+			scope = "shared"
+		}
+		if !withSize {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", cache.Name, scope, cache.Volume)
+			continue
+		}
+
+		size := "unknown"
+		if cache.Size >= 0 {
+			size = units.HumanSize(float64(cache.Size))
+			total += cache.Size
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", cache.Name, scope, cache.Volume, size)
+	}
+	if withSize {
+		fmt.Fprintf(w, "total\t\t\t%s\n", units.HumanSize(float64(total)))
+	}
+	w.Flush()
+}
@@ -3,7 +3,9 @@ package cmd
 import (
 	"github.com/spf13/cobra"
 	"os"
+	"strings"
 
+	"github.com/mercierc/pauli/logs"
 	"github.com/mercierc/pauli/src"
 )
 
@@ -14,8 +16,41 @@ var initCmd = &cobra.Command{
 		"  - config.yaml: A file that contains all necessary " +
 		"information about the build image.\n" +
 		"  - pauli.sh: A shell file with predefined commun functions " +
-		"to populate.",
+		"to populate.\n" +
+		"Both files are created from templates embedded in pauli, no " +
+		"network access is needed.\n" +
+		"Values not given by flags are asked interactively, unless --yes " +
+		"is set.\n" +
+		"Example: pauli init --name x --image golang --tag 1.22 --template go --yes",
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, arg []string) {
-		src.InitiateProject(os.Stdin)
+		if err := src.InitiateProject(os.Stdin, initOptions); err != nil {
+			logs.Logger.Error().Err(err).Msg("Cannot initialize the project")
+			os.Exit(ExitPauliFailure)
+		}
 	},
 }
+
+var initOptions src.InitOptions
+
+func init() {
+	flags := initCmd.Flags()
+	flags.StringVar(&initOptions.ProjectName, "name", "",
+		"Project name, the current folder name by default.")
+	flags.StringVar(&initOptions.BuildImage, "image", "",
+		"Name of the build image.")
+	flags.StringVar(&initOptions.Tag, "tag", "",
+		"Tag of the build image.")
+	flags.StringVar(&initOptions.Template, "template", "",
+		"Pre-fill config.yaml and pauli.sh for a language: "+
+			strings.Join(src.ProjectTemplateNames(), ", ")+
+			". Detected from go.mod, package.json, pyproject.toml, "+
+			"Cargo.toml or pom.xml by default.")
+	flags.BoolVarP(&initOptions.Yes, "yes", "y", false,
+		"Do not prompt, use defaults for values not given by flags.")
+	flags.BoolVar(&initOptions.Force, "force", false,
+		"Overwrite an existing .pauli folder.")
+	flags.StringVar(&initOptions.ScriptURL, "script-url", "",
+		"Download pauli.sh from this URL instead of using the embedded template, "+
+			"e.g. https://github.com/mercierc/pauli/raw/main/data/pauli.sh")
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/mercierc/pauli/logs"
+	"github.com/mercierc/pauli/src"
+)
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Pin the builder image to its digest in .pauli/pauli.lock.",
+	Long: "Pull the builder image of config.yaml and write its content " +
+		"digest in .pauli/pauli.lock. When pauli.lock exists, every command " +
+		"uses the pinned digest instead of the tag, commit it to get the " +
+		"same builder image everywhere.\n" +
+		"Run pauli lock again to update the digest.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cm, err := src.NewContainerManager()
+		if err != nil {
+			exit("lock", -1, err)
+		}
+
+		lock, err := cm.Lock(configPath)
+		if err != nil {
+			exit("lock", -1, err)
+		}
+		logs.Logger.Info().Msgf("%s pinned to %s in %s",
+			lock.Image, lock.Digest, src.LockPath(configPath))
+	},
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/moby/term"
+	"github.com/spf13/cobra"
+
+	"github.com/mercierc/pauli/logs"
+	"github.com/mercierc/pauli/src"
+)
+
+var (
+	loginUsername      string
+	loginPasswordStdin bool
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login [registry]",
+	Short: "Log in to the registry of the builder image.",
+	Long: "Check the credentials against a registry and store them in " +
+		"~/.docker/config.json, or in its credential helper, as docker " +
+		"login does. The registry is the one of the builder image of " +
+		"config.yaml by default.\n" +
+		"Example: echo $TOKEN | pauli login registry.local:5000 -u ci --password-stdin",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		server, err := loginServer(args)
+		if err != nil {
+			exit("login", -1, err)
+		}
+
+		auth := registry.AuthConfig{ServerAddress: server, Username: loginUsername}
+		stdin := bufio.NewReader(os.Stdin)
+
+		if auth.Username == "" {
+			fmt.Printf("Username: ")
+			auth.Username, _ = stdin.ReadString('\n')
+		}
+
+		if loginPasswordStdin {
+			password, _ := io.ReadAll(stdin)
+			auth.Password = string(password)
+		} else {
+			fmt.Printf("Password: ")
+			auth.Password = readPassword(stdin)
+			fmt.Println()
+		}
+		auth.Username = strings.TrimSpace(auth.Username)
+		auth.Password = strings.TrimRight(auth.Password, "\r\n")
+
+		cm, err := src.NewContainerManager()
+		if err != nil {
+			exit("login", -1, err)
+		}
+		if err := cm.Login(auth); err != nil {
+			exit("login", -1, err)
+		}
+		logs.Logger.Info().Msgf("Login succeeded on %s", server)
+	},
+}
+
+// Registry given as argument, or the registry of the builder image.
+func loginServer(args []string) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	}
+
+	confYaml, err := src.LoadConfiguration(configPath)
+	if err != nil {
+		return "", err
+	}
+	return src.RegistryServer(confYaml.ImageRef())
+}
+
+// Read a line from stdin without echoing it on a terminal.
+func readPassword(stdin *bufio.Reader) string {
+	fd, isTerminal := term.GetFdInfo(os.Stdin)
+	if isTerminal {
+		if state, err := term.SaveState(fd); err == nil {
+			term.DisableEcho(fd, state)
+			defer term.RestoreTerminal(fd, state)
+		}
+	}
+	password, _ := stdin.ReadString('\n')
+	return password
+}
+
+func init() {
+	loginCmd.Flags().StringVarP(&loginUsername, "username", "u", "",
+		"Username, asked interactively by default.")
+	loginCmd.Flags().BoolVar(&loginPasswordStdin, "password-stdin", false,
+		"Read the password from stdin.")
+}
@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"github.com/spf13/cobra"
 
@@ -11,8 +13,12 @@ import (
 )
 
 var (
-	envVars    []string
-	currentCmd string
+	envVars       []string
+	ports         []string
+	keepContainer bool
+	keepServices  bool
+	frozen        bool
+	currentCmd    string
 )
 
 func commonRun(cmd *cobra.Command, args []string) {
@@ -27,101 +33,94 @@ func commonRun(cmd *cobra.Command, args []string) {
 			logs.Logger.Error().Err(err).Msgf("To solve the issue: " +
 				"add the missing file or directory '%v' manually " +
 				"or run pauli init to initiate your project", file)
-			os.Exit(1)
+			os.Exit(ExitPauliFailure)
 		}
 	}
 
-	var cm *src.ContainerManager
-
 	// Extract container name from the current folder
 	containerName, _ := os.Getwd()
 	containerName = filepath.Base(containerName) + "_build"
 
-	cm = src.NewContainerManager(
+	cm, err := src.NewContainerManager(
 		src.WithName(containerName),
 		src.WithEnv(envVars),
+		src.WithKeep(keepContainer),
+		src.WithKeepServices(keepServices),
+		src.WithPorts(ports),
+		src.WithFrozen(frozen),
 		src.WithConfigYaml(configPath, false),
-		src.WithCmd(append([]string{"/bin/sh", ".pauli/pauli.sh", currentCmd}, args...)),
+		src.WithTask(currentCmd, args...),
 	)
-	cm.Start()
-	cm.Exec()
-}
-
-var buildCmd = &cobra.Command{
-	Use:   "build",
-	Short: "Execute the build from pauli.sh",
-	Long: "Launch a build container and execute the build function " +
-		"from pauli.sh.",
-	PreRun: func(cmd *cobra.Command, args []string) {
-		currentCmd = "build"
-	},
-	Run: commonRun,
-}
+	if err != nil {
+		exit(currentCmd, -1, err)
+	}
+	trapSignals(cm, currentCmd)
 
-var runCmd = &cobra.Command{
-	Use:   "run",
-	Short: "Execute the run from pauli.sh",
-	Long: "Launch a build container and execute the run function " +
-		"from pauli.sh.",
-	PreRun: func(cmd *cobra.Command, args []string) {
-		currentCmd = "run"
-	},
-	Run: commonRun,
+	if err := cm.Start(); err != nil {
+		cm.StopServices()
+		exit(currentCmd, -1, err)
+	}
+	exitCode, err := cm.Exec()
+	if stopErr := cm.StopServices(); err == nil {
+		err = stopErr
+	}
+	exit(currentCmd, exitCode, err)
 }
 
-var cleanCmd = &cobra.Command{
-	Use:   "clean",
-	Short: "Execute the clean from pauli.sh",
-	Long: "Launch a build container and execute the clean function " +
-		"from pauli.sh.",
-	PreRun: func(cmd *cobra.Command, args []string) {
-		currentCmd = "clean"
-	},
-	Run: commonRun,
-}
+// Exit with the exit code of the task, or with ExitPauliFailure when pauli
+// itself failed. Return normally when the task succeeded.
+func exit(task string, exitCode int, err error) {
+	if err != nil {
+		logs.Logger.Error().Err(err).Msgf("pauli failed to execute %s", task)
+		os.Exit(ExitPauliFailure)
+	}
 
-var lintCmd = &cobra.Command{
-	Use:   "lint",
-	Short: "Execute the lint from pauli.sh",
-	Long: "Launch a build container and execute the lint function " +
-		"from pauli.sh.",
-	PreRun: func(cmd *cobra.Command, args []string) {
-		currentCmd = "lint"
-	},
-	Run: commonRun,
+	// Propagate the exit code of the pauli.sh function.
+	if exitCode != 0 {
+		logs.Logger.Error().Msgf("%s exited with code %d", task, exitCode)
+		os.Exit(exitCode)
+	}
 }
 
-var unittestsCmd = &cobra.Command{
-	Use:   "unittests",
-	Short: "Execute the unittests from pauli.sh",
-	Long: "Launch a build container and execute the unittests function " +
-		"from pauli.sh.",
-	PreRun: func(cmd *cobra.Command, args []string) {
-		currentCmd = "unittests"
-	},
-	Run: commonRun,
+// On SIGINT or SIGTERM, stop the build container and the services before
+// exiting with 128 + the signal number, as shells do.
+func trapSignals(cm *src.ContainerManager, task string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		logs.Logger.Warn().Msgf("%s interrupted by %s", task, sig)
+		if err := cm.Interrupt(); err != nil {
+			logs.Logger.Error().Err(err).Msg("pauli failed to stop the services")
+		}
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
 }
 
-var inttestsCmd = &cobra.Command{
-	Use:   "inttests",
-	Short: "Execute the inttests from pauli.sh",
-	Long: "Launch a build container and execute the inttests function " +
-		"from pauli.sh.",
-	PreRun: func(cmd *cobra.Command, args []string) {
-		currentCmd = "inttests"
-	},
-	Run: commonRun,
-}
+// Create the command calling a function of pauli.sh.
+// The description comes from the ## comment above the function.
+func newTaskCmd(task src.Task) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   task.Name + " [flags] [-- args...]",
+		Short: "Execute the " + task.Name + " from pauli.sh",
+		Long: "Launch a build container and execute the " + task.Name +
+			" function from pauli.sh.",
+		PreRun: func(cmd *cobra.Command, args []string) {
+			currentCmd = task.Name
+		},
+		// Arguments after -- or after the first argument are passed to
+		// the function, e.g. pauli build -- -tags integration
+		Args: cobra.ArbitraryArgs,
+		Run:  commonRun,
+	}
+	cmd.Flags().SetInterspersed(false)
 
-var staticanalysisCmd = &cobra.Command{
-	Use:   "staticanalysis",
-	Short: "Execute the staticanalysis from pauli.sh",
-	Long: "Launch a build container and execute the staticanalysis function " +
-		"from pauli.sh.",
-	PreRun: func(cmd *cobra.Command, args []string) {
-		currentCmd = "staticanalysis"
-	},
-	Run: commonRun,
+	if task.Short != "" {
+		cmd.Short = task.Short
+		cmd.Long = task.Long + "\n\n" + cmd.Long
+	}
+	return cmd
 }
 
 var shellCmd = &cobra.Command{
@@ -142,11 +141,23 @@ var shellCmd = &cobra.Command{
 		containerName = filepath.Base(containerName) + "_build"
 
 		logs.Logger.Trace().Msgf("shell %s", args[0])
-		cm := src.NewContainerManager(
+		cm, err := src.NewContainerManager(
 			src.WithName(containerName),
 			src.WithEnv(envVars),
+			src.WithKeep(keepContainer),
+			src.WithKeepServices(keepServices),
+			src.WithPorts(ports),
+			src.WithFrozen(frozen),
 			src.WithConfigYaml(configPath, true),
 		)
-		cm.Shell(args[0])
+		if err != nil {
+			exit("shell", -1, err)
+		}
+		trapSignals(cm, "shell")
+		exitCode, err := cm.Shell(args[0])
+		if stopErr := cm.StopServices(); err == nil {
+			err = stopErr
+		}
+		exit("shell", exitCode, err)
 	},
 }
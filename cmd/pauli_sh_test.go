@@ -7,6 +7,8 @@ import (
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/mercierc/pauli/src"
 )
 
 func CopyFile(sourceFile string, destinationFile string) {
@@ -76,7 +78,7 @@ func TestCLIrun(t *testing.T) {
 // Let src/config_test.go TestInitiateProject to verify if the project is
 // correctly initialized
 func TestCLIinit(t *testing.T) {
-	rootCmd.SetArgs([]string{"init"})
+	rootCmd.SetArgs([]string{"init", "--force", "--yes"})
 	rootCmd.Execute()
 
 	f1, err_sh := os.Open(".pauli/pauli.sh")
@@ -95,3 +97,22 @@ func TestCLIinit(t *testing.T) {
 		f2.Close()
 	})
 }
+
+// Ensure arguments after -- are passed to the pauli.sh function, even when
+// they look like pauli flags.
+func TestTaskCmdArgs(t *testing.T) {
+	var env []string
+	c := newTaskCmd(src.Task{Name: "build"})
+	c.Flags().StringArrayVarP(&env, "env", "e", []string{}, "")
+
+	err := c.ParseFlags([]string{"--env", "A=1", "--", "-tags", "integration", "--env", "B=2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := c.Flags().Args()
+	waited := []string{"-tags", "integration", "--env", "B=2"}
+	if len(env) != 1 || env[0] != "A=1" || strings.Join(args, " ") != strings.Join(waited, " ") {
+		t.Fatalf("Wrong parsing env=%v args=%v. Waited: env=[A=1] args=%v", env, args, waited)
+	}
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/mercierc/pauli/src"
+)
+
+var pullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Pull the builder image.",
+	Long: "Pull the builder image of config.yaml, or its digest pinned in " +
+		"pauli.lock, whatever the pull policy.\n" +
+		"The pull policy of the other commands is set by builder.pull in " +
+		"config.yaml: always, if-not-present (default) or never.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cm, err := src.NewContainerManager()
+		if err != nil {
+			exit("pull", -1, err)
+		}
+		exit("pull", 0, cm.Pull(configPath))
+	},
+}
@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"slices"
+
 	"github.com/mercierc/pauli/logs"
+	"github.com/mercierc/pauli/src"
 	"github.com/spf13/cobra"
 )
 
@@ -19,6 +22,11 @@ var rootCmd = &cobra.Command{
 	Version: "0.0.5",
 }
 
+// Exit code of pauli when docker or pauli itself failed. When a task of
+// pauli.sh fails, pauli exits with the exit code of the task instead.
+// 125 is the value used by docker run for the same purpose.
+const ExitPauliFailure = 125
+
 var pauliShPath = ".pauli/pauli.sh"
 var configPath = ".pauli/config.yaml"
 
@@ -37,23 +45,42 @@ func init() {
 		"Log level: trace, debug, info, warn, error or panic)")
 }
 
+// Names of pauli commands, pauli.sh functions with these names are ignored.
+var reservedNames = []string{"init", "shell", "tasks", "list", "lock", "pull", "login", "cache", "help", "completion"}
+
 // Parse the command line.
 func Parse() error {
 	rootCmd.AddCommand(initCmd)
+	rootCmd.AddCommand(tasksCmd)
+	rootCmd.AddCommand(lockCmd)
+	rootCmd.AddCommand(pullCmd)
+	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(cacheCmd)
+
+	// Add a command per function defined in pauli.sh.
+	tasks, err := src.LoadTasks(pauliShPath)
+	if err != nil {
+		tasks = src.DefaultTasks
+	}
+
+	commands := []*cobra.Command{shellCmd}
+	for _, task := range tasks {
+		if !slices.Contains(reservedNames, task.Name) {
+			commands = append(commands, newTaskCmd(task))
+		}
+	}
 
-	// Add all the commands defined in pau_sh.go
-	for _, c := range []*cobra.Command{
-		buildCmd,
-		runCmd,
-		cleanCmd,
-		lintCmd,
-		unittestsCmd,
-		inttestsCmd,
-		staticanalysisCmd,
-		shellCmd,
-	} {
+	for _, c := range commands {
 		c.Flags().StringArrayVarP(&envVars, "env",
-			"e", []string{}, "--env K11=V1 --env K2=V2")
+			"e", []string{}, "--env K11=V1 --env K2=V2, --env K takes K from the host")
+		c.Flags().StringArrayVarP(&ports, "publish",
+			"p", []string{}, "Publish a port of the build container, e.g. -p 8080:80")
+		c.Flags().BoolVar(&keepContainer, "keep", false,
+			"Keep the build container even if config.yaml changed.")
+		c.Flags().BoolVar(&keepServices, "keep-services", false,
+			"Keep the services of config.yaml running after the command.")
+		c.Flags().BoolVar(&frozen, "frozen", false,
+			"Fail if pauli.lock is missing or does not match the builder image.")
 
 		rootCmd.AddCommand(c)
 	}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mercierc/pauli/logs"
+	"github.com/mercierc/pauli/src"
+)
+
+var tasksOutput string
+
+var tasksCmd = &cobra.Command{
+	Use:     "tasks",
+	Aliases: []string{"list"},
+	Short:   "List the functions of pauli.sh callable with pauli.",
+	Long: "List the functions of pauli.sh with their description, taken " +
+		"from the ## comment above each function.\n" +
+		"With --output json, print the list as json for editor integration.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if tasksOutput != "table" && tasksOutput != "json" {
+			logs.Logger.Error().Msgf("Unknown output %s, waited table or json", tasksOutput)
+			os.Exit(ExitPauliFailure)
+		}
+
+		tasks, err := src.LoadTasks(pauliShPath)
+		if err != nil {
+			logs.Logger.Error().Err(err).Msgf("Cannot read %s", pauliShPath)
+			os.Exit(ExitPauliFailure)
+		}
+
+		if tasksOutput == "json" {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			encoder.Encode(tasks)
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, task := range tasks {
+			fmt.Fprintf(w, "%s\t%s\n", task.Name, task.Short)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	tasksCmd.Flags().StringVarP(&tasksOutput, "output", "o", "table",
+		"Output format, table or json.")
+}
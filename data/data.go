@@ -0,0 +1,14 @@
+// Package data embeds the templates used by pauli init in the pauli binary.
+package data
+
+import _ "embed"
+
+// Template of .pauli/config.yaml, filled with src.Initiate.
+//
+//go:embed config.yaml.tmpl
+var ConfigTemplate string
+
+// Template of .pauli/pauli.sh.
+//
+//go:embed pauli.sh
+var PauliSh []byte
@@ -1,35 +1,43 @@
 #!/bin/sh
 
-# User defined functions.
+# User defined functions. Every function is a pauli command, the ## comment
+# above a function is its description in pauli --help and pauli tasks.
 
 function preinstall(){
     echo "preinstall not implemented."
 }
 
+## Build the project.
 function build(){
     echo "build not implemented."
 }
 
+## Run the project.
 function run(){
     echo "run not implemented."
 }
 
+## Remove the build artifacts.
 function clean(){
     echo "clean not implemented."
 }
 
+## Lint the sources.
 function lint(){
     echo "lint not implemented."
 }
 
+## Run the unit tests.
 function unittests(){
     echo "unittests not implemented."
 }
 
+## Run the integration tests.
 function inttests(){
     echo "inttests not implemented."
 }
 
+## Run the static analysis.
 function staticanalysis(){
     echo "staticanalysis not implemented."
 }
@@ -52,22 +60,12 @@ function warn(){
 
 preinstall
 
-case $1 in
-	build)
-		build ;;
-	run)
-	        run ;;
-	clean)
-	        clean ;;
-	unittests)
-		unittests "${@:2}" ;;
-	lint)
-		lint ;;
-	inttests)
-		inttests ;;
-	staticanalysis)
-		staticanalysis "${@:2}" ;;
-	*)
-		fatal "Unknown command"
-		exit 1;;
-esac
+# Call the function given as first argument with the remaining arguments.
+# Every function defined above is a pauli command:
+# pauli <function> [-- args...]
+if [ -n "$1" ] && [ "$(command -v "$1")" = "$1" ]; then
+	"$@"
+else
+	fatal "Unknown command $1"
+	exit 1
+fi
@@ -0,0 +1,237 @@
+package src
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+
+	"github.com/mercierc/pauli/logs"
+)
+
+// Key of Docker Hub in ~/.docker/config.json.
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// Environment variables overriding every other source of credentials.
+const (
+	RegistryUsernameEnv = "PAULI_REGISTRY_USERNAME"
+	RegistryPasswordEnv = "PAULI_REGISTRY_PASSWORD"
+)
+
+// builder.registry: credentials of the registry of the builder image.
+// ${HOST_VAR} is replaced by the value of HOST_VAR on the host, so that
+// secrets stay out of config.yaml.
+type RegistryCredentials struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// The parts of ~/.docker/config.json used by pauli.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// Path of the docker CLI configuration, honoring DOCKER_CONFIG.
+func dockerConfigPath() string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".docker")
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// Registry hosting image, as written in ~/.docker/config.json.
+func RegistryServer(image string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", err
+	}
+
+	domain := reference.Domain(named)
+	if domain == "docker.io" {
+		return dockerHubServer, nil
+	}
+	return domain, nil
+}
+
+// Find the credentials of the registry of image. From the highest to the
+// lowest precedence: the PAULI_REGISTRY_USERNAME and PAULI_REGISTRY_PASSWORD
+// environment variables, builder.registry of config.yaml, then
+// ~/.docker/config.json and its credential helpers. The first two are the
+// credentials of the registry of builderImage and are only used for images of
+// this registry, e.g. not for a service image of Docker Hub. Return empty
+// credentials for anonymous pulls.
+func ResolveRegistryAuth(image, builderImage string, creds *RegistryCredentials) (registry.AuthConfig, error) {
+	server, err := RegistryServer(image)
+	if err != nil {
+		return registry.AuthConfig{}, err
+	}
+	auth := registry.AuthConfig{ServerAddress: server}
+
+	builderServer, err := RegistryServer(builderImage)
+	if err == nil && builderServer == server {
+		if username, ok := os.LookupEnv(RegistryUsernameEnv); ok {
+			auth.Username, auth.Password = username, os.Getenv(RegistryPasswordEnv)
+			return auth, nil
+		}
+	} else {
+		creds = nil
+	}
+
+	if creds != nil {
+		resolved := interpolate([]string{creds.Username, creds.Password}, os.LookupEnv)
+		auth.Username, auth.Password = resolved[0], resolved[1]
+		return auth, nil
+	}
+
+	config, err := loadDockerConfig()
+	if err != nil {
+		return auth, err
+	}
+
+	if helper := valueOr(config.CredHelpers[server], config.CredsStore); helper != "" {
+		return credentialHelperGet(helper, server)
+	}
+
+	entry, ok := config.Auths[server]
+	if !ok {
+		return auth, nil
+	}
+	auth.IdentityToken = entry.IdentityToken
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return auth, fmt.Errorf("%s: auth of %s: %w", dockerConfigPath(), server, err)
+		}
+		auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+	}
+	return auth, nil
+}
+
+// Encode the credentials of the registry of image for the docker API, empty
+// for anonymous pulls.
+func encodedRegistryAuth(image, builderImage string, creds *RegistryCredentials) (string, error) {
+	auth, err := ResolveRegistryAuth(image, builderImage, creds)
+	if err != nil {
+		return "", err
+	}
+	if auth.Username == "" && auth.IdentityToken == "" {
+		return "", nil
+	}
+	logs.Logger.Debug().Msgf("Authenticate to %s as %s", auth.ServerAddress, auth.Username)
+	return registry.EncodeAuthConfig(auth)
+}
+
+// Read ~/.docker/config.json, an empty configuration if it does not exist.
+func loadDockerConfig() (dockerConfig, error) {
+	var config dockerConfig
+
+	content, err := os.ReadFile(dockerConfigPath())
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err = json.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("%s: %w", dockerConfigPath(), err)
+	}
+	return config, nil
+}
+
+// Message exchanged with docker-credential-<helper>.
+type helperCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// Get the credentials of server from docker-credential-<helper>.
+func credentialHelperGet(helper, server string) (registry.AuthConfig, error) {
+	auth := registry.AuthConfig{ServerAddress: server}
+
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	out, err := cmd.Output()
+	if err != nil {
+		// Helpers fail when they have no credentials for the server.
+		if bytes.Contains(out, []byte("credentials not found")) {
+			return auth, nil
+		}
+		return auth, fmt.Errorf("docker-credential-%s get: %w: %s", helper, err, out)
+	}
+
+	var creds helperCredentials
+	if err = json.Unmarshal(out, &creds); err != nil {
+		return auth, fmt.Errorf("docker-credential-%s get: %w", helper, err)
+	}
+
+	// A <token> user name means the secret is an identity token.
+	if creds.Username == "<token>" {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username, auth.Password = creds.Username, creds.Secret
+	}
+	return auth, nil
+}
+
+// Store the credentials of auth.ServerAddress, with the credential helper of
+// ~/.docker/config.json if any, in ~/.docker/config.json otherwise.
+func SaveRegistryAuth(auth registry.AuthConfig) error {
+	config, err := loadDockerConfig()
+	if err != nil {
+		return err
+	}
+
+	server := auth.ServerAddress
+	if helper := valueOr(config.CredHelpers[server], config.CredsStore); helper != "" {
+		creds, _ := json.Marshal(helperCredentials{server, auth.Username, auth.Password})
+		cmd := exec.Command("docker-credential-"+helper, "store")
+		cmd.Stdin = bytes.NewReader(creds)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("docker-credential-%s store: %w: %s", helper, err, out)
+		}
+		return nil
+	}
+
+	// Keep every other setting of the file untouched.
+	path := dockerConfigPath()
+	raw := map[string]json.RawMessage{}
+	if content, err := os.ReadFile(path); err == nil {
+		if err = json.Unmarshal(content, &raw); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	auths := map[string]json.RawMessage{}
+	if raw["auths"] != nil {
+		if err := json.Unmarshal(raw["auths"], &auths); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+	auths[server], _ = json.Marshal(map[string]string{"auth": encoded})
+	raw["auths"], _ = json.Marshal(auths)
+
+	content, err := json.MarshalIndent(raw, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
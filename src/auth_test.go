@@ -0,0 +1,116 @@
+package src
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+)
+
+// Ensure the registry of an image is named as in ~/.docker/config.json.
+func TestRegistryServer(t *testing.T) {
+	cases := map[string]string{
+		"golang:alpine":                       dockerHubServer,
+		"docker.io/library/golang":            dockerHubServer,
+		"registry.local:5000/team/builder:v1": "registry.local:5000",
+		"ghcr.io/me/builder@sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef": "ghcr.io",
+	}
+	for image, waited := range cases {
+		if server, err := RegistryServer(image); err != nil || server != waited {
+			t.Errorf("%s: wrong registry %s, %v. Waited: %s", image, server, err, waited)
+		}
+	}
+}
+
+// Ensure credentials are read from every source, in order of precedence.
+func TestResolveRegistryAuth(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// A credential helper answering for helped.local only.
+	helper := `#!/bin/sh
+read server
+if [ "$server" = "helped.local" ]; then
+	echo '{"ServerURL":"helped.local","Username":"helper","Secret":"s3cret"}'
+else
+	echo "credentials not found in native keychain"
+	exit 1
+fi
+`
+	os.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(helper), 0755)
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("war:peace"))
+	config := `{
+	"auths": {"registry.local:5000": {"auth": "` + encoded + `"}},
+	"credHelpers": {"helped.local": "fake", "unknown.local": "fake"}
+}`
+	os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600)
+
+	cases := []struct {
+		image              string
+		creds              *RegistryCredentials
+		username, password string
+	}{
+		{"registry.local:5000/builder", nil, "war", "peace"},
+		{"helped.local/builder", nil, "helper", "s3cret"},
+		{"unknown.local/builder", nil, "", ""},
+		{"golang", nil, "", ""},
+		{"registry.local:5000/builder", &RegistryCredentials{"ci", "${TOKEN}"}, "ci", "t0ken"},
+	}
+
+	t.Setenv("TOKEN", "t0ken")
+	var auth registry.AuthConfig
+	for _, c := range cases {
+		var err error
+		auth, err = ResolveRegistryAuth(c.image, c.image, c.creds)
+		if err != nil || auth.Username != c.username || auth.Password != c.password {
+			t.Errorf("%s: wrong credentials %s:%s, %v. Waited: %s:%s",
+				c.image, auth.Username, auth.Password, err, c.username, c.password)
+		}
+	}
+
+	// Environment variables win.
+	t.Setenv(RegistryUsernameEnv, "env")
+	t.Setenv(RegistryPasswordEnv, "pass")
+	auth, _ = ResolveRegistryAuth("registry.local:5000/builder", "registry.local:5000/builder",
+		&RegistryCredentials{"ci", "x"})
+	if auth.Username != "env" || auth.Password != "pass" {
+		t.Errorf("Wrong credentials %s:%s. Waited: env:pass", auth.Username, auth.Password)
+	}
+
+	// A service image of another registry is pulled without the credentials
+	// of the builder image.
+	encoded, err := encodedRegistryAuth("postgres:16", "registry.local:5000/builder",
+		&RegistryCredentials{"ci", "x"})
+	if err != nil || encoded != "" {
+		t.Errorf("postgres:16 pulled with credentials %s, %v", encoded, err)
+	}
+}
+
+// Ensure credentials saved by pauli login are read back and other settings
+// of config.json are kept.
+func TestSaveRegistryAuth(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"detachKeys": "ctrl-e"}`), 0600)
+
+	auth, _ := ResolveRegistryAuth("registry.local:5000/builder", "", nil)
+	auth.Username, auth.Password = "war", "peace"
+	if err := SaveRegistryAuth(auth); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := ResolveRegistryAuth("registry.local:5000/builder", "", nil)
+	if err != nil || saved.Username != "war" || saved.Password != "peace" {
+		t.Fatalf("Wrong credentials %s:%s, %v. Waited: war:peace", saved.Username, saved.Password, err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(dir, "config.json"))
+	if !bytes.Contains(content, []byte("ctrl-e")) {
+		t.Fatalf("Settings lost:\n%s", content)
+	}
+}
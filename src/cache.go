@@ -0,0 +1,173 @@
+package src
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+
+	"github.com/mercierc/pauli/logs"
+)
+
+// A folder of the build container, e.g. /go/pkg/mod, kept in a named volume
+// across container recreations.
+type Cache struct {
+	Name   string `yaml:"name"`
+	Target string `yaml:"target"`
+	// Share the cache with the other projects having a shared cache of the
+	// same name, instead of a cache per project.
+	Shared bool `yaml:"shared"`
+}
+
+// Cache names are part of the volume names, see volumeName. Unlike service
+// names, they can't contain dots.
+var cacheNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// Labels of the cache volumes. Shared caches have no project.
+const (
+	cacheLabel   = "pauli.cache"
+	projectLabel = "pauli.project"
+)
+
+// Ensure the cache can be mounted.
+func (c Cache) Validate() error {
+	if !cacheNameRegexp.MatchString(c.Name) {
+		return fmt.Errorf("cache %s: invalid name", c.Name)
+	}
+	if !path.IsAbs(c.Target) {
+		return fmt.Errorf("cache %s: target %s is not an absolute path", c.Name, c.Target)
+	}
+	return nil
+}
+
+// Name of the docker volume of the cache for project, pauli_<project>.<name>
+// or pauli.<name> when shared. The name follows the last dot, since it has
+// none, so two projects or caches never share a volume by accident.
+func (c Cache) volumeName(project string) string {
+	if c.Shared {
+		return "pauli." + c.Name
+	}
+	return "pauli_" + project + "." + c.Name
+}
+
+// A cache volume, as listed by pauli cache.
+type CacheVolume struct {
+	Name   string
+	Volume string
+	Shared bool
+	Size   int64 // -1 when unknown.
+}
+
+// Create the labelled volumes of the caches and return their mounts.
+func (c *ContainerManager) ensureCaches(caches []Cache) ([]mount.Mount, error) {
+	mounts := make([]mount.Mount, len(caches))
+
+	for i, cache := range caches {
+		labels := map[string]string{cacheLabel: cache.Name}
+		if !cache.Shared {
+			labels[projectLabel] = c.projectName()
+		}
+
+		// Creating an existing volume does nothing.
+		name := cache.volumeName(c.projectName())
+		_, err := c.cli.VolumeCreate(c.ctx, volume.CreateOptions{Name: name, Labels: labels})
+		if err != nil {
+			return nil, newDockerError("volume create "+name, err)
+		}
+
+		mounts[i] = mount.Mount{Type: mount.TypeVolume, Source: name, Target: cache.Target}
+		logs.Logger.Info().Msgf("Cache %s mounted to %s", cache.Name, cache.Target)
+	}
+	return mounts, nil
+}
+
+// List the caches of the project and the shared caches, by name. Sizes are
+// only computed when withSize is set, docker has to walk the volumes.
+func (c *ContainerManager) Caches(withSize bool) ([]CacheVolume, error) {
+	list, err := c.cli.VolumeList(c.ctx, volume.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", cacheLabel)),
+	})
+	if err != nil {
+		return nil, newDockerError("volume ls", err)
+	}
+
+	sizes := map[string]int64{}
+	if withSize {
+		usage, err := c.cli.DiskUsage(c.ctx, types.DiskUsageOptions{
+			Types: []types.DiskUsageObject{types.VolumeObject},
+		})
+		if err != nil {
+			return nil, newDockerError("system df", err)
+		}
+		for _, v := range usage.Volumes {
+			if v.UsageData != nil {
+				sizes[v.Name] = v.UsageData.Size
+			}
+		}
+	}
+
+	var caches []CacheVolume
+	for _, v := range list.Volumes {
+		project, shared := v.Labels[projectLabel], v.Labels[projectLabel] == ""
+		if !shared && project != c.projectName() {
+			continue
+		}
+
+		size, ok := sizes[v.Name]
+		if !ok {
+			size = -1
+		}
+		caches = append(caches, CacheVolume{
+			Name:   v.Labels[cacheLabel],
+			Volume: v.Name,
+			Shared: shared,
+			Size:   size,
+		})
+	}
+
+	sort.Slice(caches, func(i, j int) bool { return caches[i].Name < caches[j].Name })
+	return caches, nil
+}
+
+// Remove the cache called name, or every cache of the project when name is
+// empty. Shared caches are only removed by name. The build container, which
+// holds the caches, is removed first and recreated by the next command.
+func (c *ContainerManager) ClearCaches(name string) error {
+	caches, err := c.Caches(false)
+	if err != nil {
+		return err
+	}
+
+	var volumes []string
+	for _, cache := range caches {
+		if cache.Name == name || (name == "" && !cache.Shared) {
+			volumes = append(volumes, cache.Volume)
+		}
+	}
+	if name != "" && len(volumes) == 0 {
+		return fmt.Errorf("no cache %s", name)
+	}
+	if len(volumes) == 0 {
+		logs.Logger.Info().Msg("No cache to clear")
+		return nil
+	}
+
+	err = c.cli.ContainerRemove(c.ctx, c.containerName, types.ContainerRemoveOptions{Force: true})
+	if err != nil && !errdefs.IsNotFound(err) {
+		return newDockerError("remove "+c.containerName, err)
+	}
+
+	for _, v := range volumes {
+		if err := c.cli.VolumeRemove(c.ctx, v, false); err != nil {
+			return newDockerError("volume rm "+v, err)
+		}
+		logs.Logger.Info().Msgf("Cache volume %s removed", v)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package src
+
+import "testing"
+
+// Ensure caches are validated and mapped to project or shared volumes.
+func TestCache(t *testing.T) {
+	cache := Cache{Name: "gomod", Target: "/go/pkg/mod"}
+	if err := cache.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if name := cache.volumeName("my_app"); name != "pauli_my_app.gomod" {
+		t.Fatalf("Wrong volume %s. Waited: pauli_my_app.gomod", name)
+	}
+	// Underscores are allowed in both the project and the cache names.
+	if name := (Cache{Name: "app_gomod"}).volumeName("my"); name == cache.volumeName("my_app") {
+		t.Fatalf("Volume %s shared by two projects.", name)
+	}
+	cache.Shared = true
+	if name := cache.volumeName("my_app"); name != "pauli.gomod" {
+		t.Fatalf("Wrong volume %s. Waited: pauli.gomod", name)
+	}
+	if name := (Cache{Name: "gomod"}).volumeName("cache"); name == cache.volumeName("my_app") {
+		t.Fatalf("Volume %s shared by a project and a shared cache.", name)
+	}
+
+	for _, invalid := range []Cache{{Target: "/go/pkg/mod"}, {Name: "go mod", Target: "/go/pkg/mod"},
+		{Name: "go.mod", Target: "/go/pkg/mod"},
+		{Name: "gomod", Target: "go/pkg/mod"}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("%+v should be invalid", invalid)
+		}
+	}
+
+	conf := Configuration{Builder: Builder{Image: "golang", Caches: []Cache{
+		{Name: "gomod", Target: "/go/pkg/mod"},
+		{Name: "gomod", Target: "/root/.cache/go-build"},
+	}}}
+	if err := conf.Validate(); err == nil {
+		t.Error("Duplicated cache accepted.")
+	}
+}
@@ -2,125 +2,387 @@ package src
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"github.com/docker/go-units"
+	"github.com/mercierc/pauli/data"
 	"github.com/mercierc/pauli/logs"
+	"gopkg.in/yaml.v2"
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"text/template"
+	"time"
 )
 
+// A volume mounted in the build container.
+//   - bind: mount the host path Source, relative paths start from the project.
+//   - volume: mount the docker named volume Source, created on demand.
+//   - tmpfs: mount a tmpfs of Size bytes (e.g. 64m), Source must be empty.
 type Volume struct {
-	Type   string `yaml:"type"`
-	Source string `yaml:"source"`
-	Target string `yaml:"target"`
+	Type        string `yaml:"type"`
+	Source      string `yaml:"source"`
+	Target      string `yaml:"target"`
+	ReadOnly    bool   `yaml:"read_only"`
+	Size        string `yaml:"size"`        // tmpfs only.
+	Propagation string `yaml:"propagation"` // bind only, e.g. rslave.
+}
+
+var bindPropagations = []string{
+	"private", "rprivate", "shared", "rshared", "slave", "rslave",
+}
+
+// Ensure the volume is consistent with its type.
+func (v Volume) Validate() error {
+	if v.Target == "" {
+		return fmt.Errorf("volume %s: target is missing", v.Source)
+	}
+
+	if v.Propagation != "" && v.Type != "bind" && v.Type != "" {
+		return fmt.Errorf("volume %s: propagation is only allowed for bind volumes", v.Target)
+	}
+	if v.Size != "" && v.Type != "tmpfs" {
+		return fmt.Errorf("volume %s: size is only allowed for tmpfs volumes", v.Target)
+	}
+
+	switch v.Type {
+	case "", "bind":
+		if v.Source == "" {
+			return fmt.Errorf("volume %s: source is missing", v.Target)
+		}
+		if v.Propagation != "" && !slices.Contains(bindPropagations, v.Propagation) {
+			return fmt.Errorf("volume %s: unknown propagation %s, waited one of %v",
+				v.Target, v.Propagation, bindPropagations)
+		}
+	case "volume":
+	case "tmpfs":
+		if v.Source != "" {
+			return fmt.Errorf("volume %s: tmpfs volumes have no source", v.Target)
+		}
+		if v.Size != "" {
+			if _, err := units.RAMInBytes(v.Size); err != nil {
+				return fmt.Errorf("volume %s: %w", v.Target, err)
+			}
+		}
+	default:
+		return fmt.Errorf("volume %s: unknown type %s, waited bind, volume or tmpfs",
+			v.Target, v.Type)
+	}
+	return nil
 }
 
 type Builder struct {
-	Image      string   `yaml:"image"`
-	Tag        string   `yaml:"tag"`
-	Privileged bool     `yaml:"privileged"`
-	Volumes    []Volume `yaml:"volumes"`
+	Image      string               `yaml:"image"`
+	Tag        string               `yaml:"tag"`
+	Build      *Build               `yaml:"build"`
+	Pull       string               `yaml:"pull"` // always, if-not-present (default) or never.
+	Registry   *RegistryCredentials `yaml:"registry"`
+	Resources  Resources            `yaml:"resources"`
+	Privileged bool                 `yaml:"privileged"`
+	Volumes    []Volume             `yaml:"volumes"`
+	Caches     []Cache              `yaml:"caches"`
+	Env        EnvVars              `yaml:"env"`
+	EnvFile    StringList           `yaml:"env_file"`
+	// bridge (default), host, none or the name of an existing network.
+	Network    string     `yaml:"network"`
+	Ports      StringList `yaml:"ports"`       // As docker run -p, e.g. 8080:80.
+	ExtraHosts StringList `yaml:"extra_hosts"` // name:ip entries of /etc/hosts.
+	DNS        StringList `yaml:"dns"`
+	// Capabilities, e.g. SYS_PTRACE, added to or dropped from the default
+	// ones. Prefer them to privileged.
+	CapAdd          StringList `yaml:"cap_add"`
+	CapDrop         StringList `yaml:"cap_drop"`
+	SecurityOpt     StringList `yaml:"security_opt"` // e.g. seccomp=unconfined.
+	ReadOnlyRootfs  bool       `yaml:"read_only_rootfs"`
+	NoNewPrivileges bool       `yaml:"no_new_privileges"`
+	// User running the tasks: name, uid or uid:gid. host is the uid:gid of
+	// the user running pauli. Empty for the user of the image.
+	User string `yaml:"user"`
+	// Where the project is mounted, /app by default.
+	MountTarget string `yaml:"mount_target"`
+	// Mount the root of the git repository instead of the project, for
+	// projects in a subdirectory of a monorepo.
+	MountGitRoot bool `yaml:"mount_git_root"`
+	// Working directory of the tasks, relative paths start from the project
+	// in the container. The project by default.
+	Workdir string `yaml:"workdir"`
+}
+
+// Layout of the project in the build container.
+type Workspace struct {
+	Source     string // Host folder mounted in the container.
+	Target     string // Where Source is mounted.
+	ProjectDir string // The project folder in the container.
+	Workdir    string // Working directory of the tasks.
+}
+
+// Compute where the project in cwd is mounted and where tasks run.
+func (b Builder) ResolveWorkspace(cwd string) (Workspace, error) {
+	w := Workspace{Source: cwd, Target: valueOr(b.MountTarget, "/app")}
+
+	rel := "."
+	if b.MountGitRoot {
+		root, err := findGitRoot(cwd)
+		if err != nil {
+			return w, err
+		}
+		w.Source = root
+		rel, _ = filepath.Rel(root, cwd)
+	}
+	w.ProjectDir = path.Join(w.Target, filepath.ToSlash(rel))
+
+	w.Workdir = b.Workdir
+	if !path.IsAbs(w.Workdir) {
+		w.Workdir = path.Join(w.ProjectDir, w.Workdir)
+	}
+	return w, nil
+}
+
+// Return the first folder containing .git from dir up to /.
+func findGitRoot(dir string) (string, error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d, nil
+		}
+		if d == filepath.Dir(d) {
+			return "", fmt.Errorf("mount_git_root: %s is not in a git repository", dir)
+		}
+	}
+}
+
+// Resolve the user of the build container, host becoming the uid:gid of the
+// current user.
+func (b Builder) ResolveUser() string {
+	if b.User == "host" {
+		return fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	}
+	return b.User
 }
 
 type Configuration struct {
 	Builder Builder `yaml:"builder"`
 	Name    string  `yaml:"name"`
+	// Sidecar containers by name. They have their own hash, see
+	// startService.
+	Services map[string]Service `yaml:"services" json:"-"`
+	// Conditions met before executing a task.
+	WaitFor []WaitCondition `yaml:"wait_for" json:"-"`
 }
 
-var (
-	templateContent = `builder:
-  image: {{ if .BuildImage }}{{ .BuildImage }}{{ else }}<image_name>{{ end }}
-  tag: {{ if .Tag }}{{ .Tag }}{{ else }}latest{{ end }}
-  privileged: true
-  volumes:
-    - type: bind
-      source: /var/run/docker.sock
-      target: /var/run/docker.sock
-name: {{ .ProjectName }}`
-)
+// Read and parse the config.yaml file.
+func LoadConfiguration(configYamlPath string) (Configuration, error) {
+	var confYaml Configuration
 
-// Create the .pauli folder with config.yaml and download pauli.sh
-// reader: Allow to read from different inputs.
+	content, err := os.ReadFile(configYamlPath)
+	if os.IsNotExist(err) {
+		return confYaml, fmt.Errorf("%w: %w", ErrConfigNotFound, err)
+	}
+	if err != nil {
+		return confYaml, err
+	}
 
-func InitiateProject(reader io.Reader) error {
-	templateContent := `builder:
-  image: {{ if .BuildImage }}{{ .BuildImage }}{{ else }}<image_name>{{ end }}
-  tag: {{ if .Tag }}{{ .Tag }}{{ else }}latest{{ end }}
-  privileged: true
-  volumes:
-    - type: bind
-      source: /var/run/docker.sock
-      target: /var/run/docker.sock
-name: {{ .ProjectName }}`
+	if err = yaml.Unmarshal(content, &confYaml); err != nil {
+		return confYaml, fmt.Errorf("%w: %s: %w", ErrInvalidConfig, configYamlPath, err)
+	}
 
-	type Initiate struct {
-		ProjectName, BuildImage, Tag string
+	if err = confYaml.Validate(); err != nil {
+		return confYaml, fmt.Errorf("%w: %s: %w", ErrInvalidConfig, configYamlPath, err)
 	}
+	return confYaml, nil
+}
 
-	// Create the .pauli folder.
-	if err := os.MkdirAll(".pauli", os.ModePerm); err != nil {
-		logs.Logger.Error().Err(err).Msg("error")
+// Ensure the configuration can be turned into a build container.
+func (c Configuration) Validate() error {
+	if c.Builder.Image == "" && c.Builder.Build == nil {
+		return fmt.Errorf("builder.image or builder.build is required")
 	}
-	// Create the pauli.sh file.
-	file, err := os.Create(".pauli/pauli.sh")
-	if err != nil {
-		logs.Logger.Error().Err(err).Msg("error")
+	if c.Builder.Image == "" && c.imageName() == "" {
+		return fmt.Errorf("builder.image or name is required with builder.build, to name the built image")
 	}
-	defer file.Close()
-
-	// Download the raw pauli.sh file.
-	downloaded := make(chan bool)
-	go func() {
-		r, err := http.Get("https://github.com/mercierc/pauli/raw/main/data/pauli.sh")
-		if err != nil {
-			logs.Logger.Error().Err(err).Msg("error")
+	if c.Builder.Pull != "" && !slices.Contains(pullPolicies, c.Builder.Pull) {
+		return fmt.Errorf("unknown pull policy %s, waited one of %v", c.Builder.Pull, pullPolicies)
+	}
+	if _, _, err := c.Builder.Resources.parse(); err != nil {
+		return err
+	}
+	if _, err := c.Builder.parseNetwork(); err != nil {
+		return err
+	}
+	if _, err := c.Builder.parseSecurity(); err != nil {
+		return err
+	}
+	if len(c.Services) > 0 && c.Builder.Network != "" {
+		return fmt.Errorf("network must be empty with services, the build " +
+			"container joins the network of the project")
+	}
+	for _, name := range c.serviceNames() {
+		if err := c.Services[name].Validate(name); err != nil {
+			return err
+		}
+	}
+	for _, w := range c.WaitFor {
+		if err := w.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Builder.MountTarget != "" && !path.IsAbs(c.Builder.MountTarget) {
+		return fmt.Errorf("mount_target %s is not an absolute path", c.Builder.MountTarget)
+	}
+	cacheNames := map[string]bool{}
+	for _, cache := range c.Builder.Caches {
+		if err := cache.Validate(); err != nil {
+			return err
+		}
+		if cacheNames[cache.Name] {
+			return fmt.Errorf("cache %s is declared twice", cache.Name)
 		}
+		cacheNames[cache.Name] = true
+	}
+	for _, volume := range c.Builder.Volumes {
+		if err := volume.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hash of the configuration. It is stored on the build container to detect
+// when config.yaml changed since the container creation.
+func (c Configuration) Hash() string {
+	content, _ := json.Marshal(c)
+	return hashOf(string(content))
+}
+
+// Hex encoded sha256 of the concatenation of parts.
+func hashOf(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		io.WriteString(h, part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Values filling the config.yaml template.
+type Initiate struct {
+	ProjectName, BuildImage, Tag string
+}
+
+// Options of pauli init. Values left empty are asked on the reader, unless
+// Yes is set, in which case defaults are used.
+type InitOptions struct {
+	Initiate
+	Template  string // Name of a ProjectTemplates entry, detected by default.
+	ScriptURL string // Download pauli.sh instead of using the embedded one.
+	Yes       bool   // Never prompt.
+	Force     bool   // Overwrite an existing .pauli folder.
+}
+
+// Create the .pauli folder with config.yaml and pauli.sh. pauli.sh is the
+// template embedded in pauli, or is downloaded from opts.ScriptURL if set.
+// reader: Allow to read from different inputs.
+func InitiateProject(reader io.Reader, opts InitOptions) error {
+	if _, err := os.Stat(".pauli"); err == nil && !opts.Force {
+		return ErrProjectExists
+	}
+
+	cwd, _ := os.Getwd()
 
-		// Write the body in the pauli.sh file
-		if _, err := io.Copy(file, r.Body); err != nil {
-			logs.Logger.Error().Err(err).Msg("error")
+	// Without template, use the one matching the project files.
+	projectTemplate := ProjectTemplates["generic"]
+	var err error
+	if opts.Template != "" {
+		if projectTemplate, err = GetProjectTemplate(opts.Template); err != nil {
+			return err
 		}
-		// Ensure the http body is close after the end of the function.
-		defer r.Body.Close()
+	} else if name, detected, ok := DetectProject(cwd); ok {
+		logs.Logger.Info().Msgf("%s project detected, propose the %s:%s build image",
+			name, detected.Image, detected.Tag)
+		projectTemplate = detected
+	}
 
-		downloaded <- true
-		logs.Logger.Info().Msg("pauli.sh downloaded")
-	}()
+	// Create the .pauli folder.
+	if err := os.MkdirAll(".pauli", os.ModePerm); err != nil {
+		return err
+	}
 
-	i := Initiate{}
+	// Create the pauli.sh file.
+	script := data.PauliSh
+	if opts.ScriptURL != "" {
+		if script, err = downloadScript(opts.ScriptURL); err != nil {
+			return err
+		}
+		logs.Logger.Info().Msgf("pauli.sh downloaded from %s", opts.ScriptURL)
+	}
 
-	scanner := bufio.NewScanner(reader)
-	fmt.Printf("Project name (optional, cwd): ")
-	scanner.Scan()
-	i.ProjectName = scanner.Text()
+	script = projectTemplate.Render(script)
+	if err := os.WriteFile(".pauli/pauli.sh", script, 0755); err != nil {
+		return err
+	}
 
-	fmt.Printf("Name of the build image (optional, <image_name>): ")
-	scanner.Scan()
-	i.BuildImage = scanner.Text()
+	i := opts.Initiate
 
-	fmt.Printf("tag (optional, latest): ")
-	scanner.Scan()
-	i.Tag = scanner.Text()
+	scanner := bufio.NewScanner(reader)
+	ask := func(value *string, question, defaultValue string) {
+		if *value != "" {
+			return
+		}
+		if !opts.Yes {
+			fmt.Printf("%s (optional, %s): ", question, defaultValue)
+			scanner.Scan()
+			*value = scanner.Text()
+		}
+	}
+	ask(&i.ProjectName, "Project name", "cwd")
+	ask(&i.BuildImage, "Name of the build image", valueOr(projectTemplate.Image, "<image_name>"))
+	ask(&i.Tag, "tag", valueOr(projectTemplate.Tag, "latest"))
 
 	if i.ProjectName == "" {
-		i.ProjectName, _ = os.Getwd()
-		i.ProjectName = filepath.Base(i.ProjectName)
+		i.ProjectName = filepath.Base(cwd)
 	}
+	i.BuildImage = valueOr(i.BuildImage, projectTemplate.Image)
+	i.Tag = valueOr(i.Tag, projectTemplate.Tag)
 
 	// Fill the config.tmpl
-	tmpl, err := template.New("config.tmpl").Parse(templateContent)
+	tmpl, err := template.New("config.tmpl").Parse(data.ConfigTemplate)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// Apply user entries to the template and save.
 	outputFile, err := os.Create(".pauli/config.yaml")
-	err = tmpl.Execute(outputFile, i)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	logs.Logger.Info().Msgf("pauli.sh downloaded: %t", <-downloaded)
-	return nil
+	defer outputFile.Close()
+
+	return tmpl.Execute(outputFile, i)
+}
+
+// Return value, or defaultValue if value is empty.
+func valueOr(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// Download a pauli.sh template.
+func downloadScript(scriptURL string) ([]byte, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+
+	r, err := client.Get(scriptURL)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s: %s", scriptURL, r.Status)
+	}
+	return io.ReadAll(r.Body)
 }
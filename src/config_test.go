@@ -2,10 +2,13 @@ package src
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/mercierc/pauli/logs"
@@ -24,7 +27,7 @@ func TestInitiateProject(t *testing.T) {
 	_, err = file.WriteString("War\nAnd\nPeace")
 	file.Seek(0, 0)
 
-	InitiateProject(file)
+	InitiateProject(file, InitOptions{})
 
 	_, err = os.Stat(".pauli")
 
@@ -46,8 +49,13 @@ func TestInitiateProject(t *testing.T) {
 	}
 	fmt.Printf("%+v", confYaml)
 
+	// Refuse to overwrite .pauli.
+	if err = InitiateProject(file, InitOptions{}); !errors.Is(err, ErrProjectExists) {
+		t.Fatalf("Wrong error %v. Waited: %v", err, ErrProjectExists)
+	}
+
 	// By defaut
-	InitiateProject(file)
+	InitiateProject(file, InitOptions{Force: true})
 
 	// Load template from config.yaml
 	content, err = os.ReadFile(".pauli/config.yaml")
@@ -61,7 +69,7 @@ func TestInitiateProject(t *testing.T) {
 		t.Fatalf("Input for config.yaml are wrong.")
 	}
 
-	// Ensure paui.sh is downloaded.
+	// Ensure paui.sh is written.
 	f, err := os.Open(".pauli/pauli.sh")
 	scanner := bufio.NewScanner(f)
 	scanner.Scan()
@@ -73,3 +81,154 @@ func TestInitiateProject(t *testing.T) {
 	//Clean
 	os.RemoveAll(".pauli")
 }
+
+// Ensure a missing and an invalid config.yaml are distinguished.
+func TestLoadConfigurationErrors(t *testing.T) {
+	_, err := LoadConfiguration("/tmp/does_not_exist.yaml")
+	if !errors.Is(err, ErrConfigNotFound) {
+		t.Fatalf("Wrong error %v. Waited: %v", err, ErrConfigNotFound)
+	}
+
+	file, _ := ioutil.TempFile("/tmp", "config.yaml")
+	defer os.Remove(file.Name())
+	file.WriteString("builder: [not, a, map]")
+	file.Close()
+
+	_, err = LoadConfiguration(file.Name())
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("Wrong error %v. Waited: %v", err, ErrInvalidConfig)
+	}
+}
+
+// Ensure the hash only changes with the configuration.
+func TestConfigurationHash(t *testing.T) {
+	conf := Configuration{Builder: Builder{Image: "golang", Tag: "1.21"}, Name: "War"}
+	same := Configuration{Builder: Builder{Image: "golang", Tag: "1.21"}, Name: "War"}
+
+	if conf.Hash() != same.Hash() {
+		t.Fatal("Same configurations have different hashes.")
+	}
+
+	same.Builder.Privileged = true
+	if conf.Hash() == same.Hash() {
+		t.Fatal("Different configurations have the same hash.")
+	}
+}
+
+// Ensure volumes are validated according to their type.
+func TestVolumeValidate(t *testing.T) {
+	valid := []Volume{
+		{Source: "/var/run/docker.sock", Target: "/var/run/docker.sock"},
+		{Type: "bind", Source: ".", Target: "/src", ReadOnly: true, Propagation: "rslave"},
+		{Type: "volume", Source: "gomod", Target: "/go/pkg/mod"},
+		{Type: "tmpfs", Target: "/tmp", Size: "64m"},
+	}
+	invalid := []Volume{
+		{Type: "bind", Target: "/src"},
+		{Type: "bind", Source: ".", Target: "/src", Propagation: "everywhere"},
+		{Type: "volume", Source: "gomod"},
+		{Type: "volume", Source: "gomod", Target: "/go/pkg/mod", Size: "1g"},
+		{Type: "tmpfs", Source: "/tmp", Target: "/tmp"},
+		{Type: "tmpfs", Target: "/tmp", Size: "a lot"},
+		{Type: "nfs", Source: "server", Target: "/nfs"},
+	}
+
+	for _, v := range valid {
+		if err := v.Validate(); err != nil {
+			t.Errorf("%+v should be valid: %v", v, err)
+		}
+	}
+	for _, v := range invalid {
+		if err := v.Validate(); err == nil {
+			t.Errorf("%+v should be invalid", v)
+		}
+	}
+}
+
+// Ensure flags and language templates fill the project without prompting.
+func TestInitiateProjectNonInteractive(t *testing.T) {
+	defer os.RemoveAll(".pauli")
+
+	err := InitiateProject(strings.NewReader(""), InitOptions{
+		Initiate: Initiate{ProjectName: "x", Tag: "1.21"},
+		Template: "go",
+		Yes:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	confYaml, err := LoadConfiguration(".pauli/config.yaml")
+	if err != nil || confYaml.Name != "x" ||
+		confYaml.Builder.Image != "golang" || confYaml.Builder.Tag != "1.21" {
+		t.Fatalf("Wrong config.yaml %+v, %v", confYaml, err)
+	}
+
+	script, _ := os.ReadFile(".pauli/pauli.sh")
+	if !strings.Contains(string(script), `go test "$@" ./...`) ||
+		strings.Contains(string(script), "unittests not implemented.") {
+		t.Fatal("pauli.sh is not filled with the go template.")
+	}
+
+	err = InitiateProject(strings.NewReader(""), InitOptions{Template: "cobol", Force: true})
+	if err == nil {
+		t.Fatal("Unknown template accepted.")
+	}
+}
+
+// Ensure host is replaced by the uid:gid of the current user.
+func TestResolveUser(t *testing.T) {
+	host := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+
+	if user := (Builder{User: "host"}).ResolveUser(); user != host {
+		t.Fatalf("Wrong user %s. Waited: %s", user, host)
+	}
+	if user := (Builder{User: "1000:1000"}).ResolveUser(); user != "1000:1000" {
+		t.Fatalf("Wrong user %s. Waited: 1000:1000", user)
+	}
+}
+
+// Ensure the project layout in the container follows the configuration.
+func TestResolveWorkspace(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "services", "api")
+	os.MkdirAll(filepath.Join(root, ".git"), 0755)
+	os.MkdirAll(project, 0755)
+
+	cases := []struct {
+		builder Builder
+		waited  Workspace
+	}{
+		{Builder{}, Workspace{project, "/app", "/app", "/app"}},
+		{Builder{MountTarget: "/workspace", Workdir: "cmd"},
+			Workspace{project, "/workspace", "/workspace", "/workspace/cmd"}},
+		{Builder{MountGitRoot: true},
+			Workspace{root, "/app", "/app/services/api", "/app/services/api"}},
+		{Builder{MountGitRoot: true, Workdir: "/go/src/x"},
+			Workspace{root, "/app", "/app/services/api", "/go/src/x"}},
+	}
+
+	for _, c := range cases {
+		w, err := c.builder.ResolveWorkspace(project)
+		if err != nil || w != c.waited {
+			t.Errorf("%+v: wrong workspace %+v, %v. Waited: %+v", c.builder, w, err, c.waited)
+		}
+	}
+
+	if _, err := (Builder{MountGitRoot: true}).ResolveWorkspace(t.TempDir()); err == nil {
+		t.Error("mount_git_root accepted outside a git repository.")
+	}
+}
+
+// Ensure unknown pull policies are refused.
+func TestValidatePullPolicy(t *testing.T) {
+	conf := Configuration{Builder: Builder{Image: "golang", Pull: PullNever}}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Builder.Pull = "sometimes"
+	if err := conf.Validate(); err == nil {
+		t.Fatal("Unknown pull policy accepted.")
+	}
+}
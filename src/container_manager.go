@@ -2,122 +2,296 @@ package src
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/errdefs"
-	"gopkg.in/yaml.v2"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
+	"github.com/moby/term"
 
 	"github.com/mercierc/pauli/logs"
 )
 
 type ContainerManager struct {
-	cli           *client.Client // docker client
+	cli           client.APIClient // docker client
 	ctx           context.Context
+	cancel        context.CancelFunc // Cancels ctx, see Interrupt.
 	containerID   string
 	containerName string
 	cmd           []string
 	entryPoint    []string
 	env           []string
+	keep          bool     // Keep an outdated container instead of recreating it.
+	ports         []string // Published in addition to builder.ports.
+	frozen        bool     // Require an up to date pauli.lock.
+	privileged    bool     // Give extended privileges to interactive shells.
+	readOnly      bool     // Read only root file system.
+	registry      *RegistryCredentials
+	builderImage  string // registry only applies to the registry of this image.
+	services      map[string]Service
+	serviceNames  []string // In start order.
+	keepServices  bool     // Keep the services running after the tasks.
+	waitFor       []WaitCondition
+	caches        []Cache
+	reportUsage   bool // Log the peak resource usage of tasks.
+	user          string
+	workspace     Workspace
+	task          []string  // pauli.sh function and its arguments.
+	stdout        io.Writer // Output of the tasks.
+	stderr        io.Writer
 }
 
-type Opt func(*ContainerManager)
+type Opt func(*ContainerManager) error
 
-// Intialize a container manager based on passed options.
-func NewContainerManager(options ...Opt) *ContainerManager {
+// Intialize a container manager based on passed options. Options are applied
+// in order and the first failing one aborts the construction.
+func NewContainerManager(options ...Opt) (*ContainerManager, error) {
 	c := &ContainerManager{}
 
-	c.ctx = context.Background()
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.stdout, c.stderr = os.Stdout, os.Stderr
 
 	// Initialize the docker client.
-	c.cli, _ = client.NewClientWithOpts(client.WithAPIVersionNegotiation())
+	cli, err := client.NewClientWithOpts(client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, newDockerError("client", err)
+	}
+	c.cli = cli
 
 	for _, opt := range options {
-		opt(c)
+		if err := opt(c); err != nil {
+			return nil, err
+		}
 	}
-	return c
+	return c, nil
 }
 
 // Pass the command to execute in the build container.
 func WithCmd(cmd []string) Opt {
-	return func(c *ContainerManager) {
+	return func(c *ContainerManager) error {
 		c.cmd = cmd
+		return nil
+	}
+}
+
+// Pass the pauli.sh function to execute in the build container, with its
+// arguments. It replaces WithCmd.
+func WithTask(task string, args ...string) Opt {
+	return func(c *ContainerManager) error {
+		c.task = append([]string{task}, args...)
+		return nil
 	}
 }
 
 func WithEnv(env []string) Opt {
-	return func(c *ContainerManager) {
+	return func(c *ContainerManager) error {
 		c.env = env
+		return nil
 	}
 }
 
 func WithName(containerName string) Opt {
-	return func(c *ContainerManager) {
+	return func(c *ContainerManager) error {
 		c.containerName = containerName
+		return nil
 	}
 }
 
 func WithEntryPoint(entryPoint []string) Opt {
-	return func(c *ContainerManager) {
+	return func(c *ContainerManager) error {
 		c.entryPoint = entryPoint
+		return nil
+	}
+}
+
+// Fail instead of warning when pauli.lock is missing or does not match the
+// builder image. Must be passed before WithConfigYaml.
+func WithFrozen(frozen bool) Opt {
+	return func(c *ContainerManager) error {
+		c.frozen = frozen
+		return nil
+	}
+}
+
+// Keep the existing container even if config.yaml changed since its creation.
+// Must be passed before WithConfigYaml.
+func WithKeep(keep bool) Opt {
+	return func(c *ContainerManager) error {
+		c.keep = keep
+		return nil
+	}
+}
+
+// Pass ports to publish in addition to builder.ports, in the format of
+// docker run -p, e.g. 8080:80. Must be passed before WithConfigYaml.
+func WithPorts(ports []string) Opt {
+	return func(c *ContainerManager) error {
+		c.ports = ports
+		return nil
+	}
+}
+
+// Convert a validated volume of config.yaml to a docker mount.
+func (v Volume) toMount() mount.Mount {
+	m := mount.Mount{
+		Source:   v.Source,
+		Target:   v.Target,
+		ReadOnly: v.ReadOnly,
+		Type:     mount.Type(v.Type),
+	}
+
+	switch v.Type {
+	case "", "bind":
+		m.Type = mount.TypeBind
+		// Docker only accepts absolute paths.
+		m.Source, _ = filepath.Abs(v.Source)
+		if v.Propagation != "" {
+			m.BindOptions = &mount.BindOptions{
+				Propagation: mount.Propagation(v.Propagation),
+			}
+		}
+	case "tmpfs":
+		size, _ := units.RAMInBytes(v.Size)
+		m.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: size}
 	}
+	// Docker creates missing named volumes when the container is created.
+	return m
 }
 
+// Label holding the hash of the configuration a container was created with.
+const configHashLabel = "pauli.config-hash"
+
 // Intanciate the docker client and create the docker container based on the
-// config.yaml file.
+// config.yaml file. An existing container created from a different
+// configuration is recreated, unless WithKeep(true) was passed.
 func WithConfigYaml(configYamlPath string, shell bool) Opt {
-	return func(c *ContainerManager) {
+	return func(c *ContainerManager) error {
 
-		// If the container already exists, exit.
-		containerJSON, err := c.cli.ContainerInspect(context.Background(), c.containerName)
-		
-		if containerJSON.ContainerJSONBase != nil {
-			c.containerID = containerJSON.ID
-			return
+		// Extract configuration from cofnfig.yaml.
+		confYaml, err := LoadConfiguration(configYamlPath)
+		if err != nil {
+			return err
+		}
+		// Ports of the -p flags are published as builder.ports, a new port
+		// requires a new container.
+		if len(c.ports) > 0 {
+			confYaml.Builder.Ports = append(confYaml.Builder.Ports, c.ports...)
+			if _, err := confYaml.Builder.parseNetwork(); err != nil {
+				return err
+			}
+		}
+		configHash := confYaml.Hash()
+		image := confYaml.ImageRef()
+		c.registry, c.builderImage = confYaml.Builder.Registry, confYaml.ImageRef()
+		c.reportUsage = confYaml.Builder.Resources.isSet()
+		c.privileged = confYaml.Builder.Privileged
+		c.readOnly = confYaml.Builder.ReadOnlyRootfs
+		c.services = confYaml.Services
+		c.serviceNames = confYaml.serviceNames()
+		c.waitFor = confYaml.WaitFor
+		c.caches = confYaml.Builder.Caches
+		if len(c.services) > 0 {
+			// The build container joins the network of the services.
+			configHash = hashOf(configHash, c.networkName())
 		}
 
-		// Extract configuration from cofnfig.yaml.
-		content, err := os.ReadFile(configYamlPath)
+		// Build the builder image. A new image requires a new container.
+		if confYaml.Builder.Build != nil {
+			buildHash, err := c.buildImage(*confYaml.Builder.Build, image)
+			if err != nil {
+				return err
+			}
+			configHash = hashOf(configHash, buildHash)
+		} else {
+			// Use the digest pinned in pauli.lock.
+			if image, err = c.lockedImage(LockPath(configYamlPath), image); err != nil {
+				return err
+			}
+
+			// A new image, e.g. pulled with the always policy, requires a
+			// new container.
+			imageID, err := c.ensureImage(image, valueOr(confYaml.Builder.Pull, PullIfNotPresent))
+			if err != nil {
+				return err
+			}
+			configHash = hashOf(configHash, imageID)
+		}
 
+		// Add the environment of config.yaml to the --env flags.
+		c.env, err = confYaml.Builder.ResolveEnv(c.env, os.LookupEnv)
 		if err != nil {
-			logs.Logger.Error().Err(err).Msg("error")
-			panic(err)
+			return fmt.Errorf("%w: %s: %w", ErrInvalidConfig, configYamlPath, err)
 		}
+		c.user = confYaml.Builder.ResolveUser()
 
-		// Parse yaml config  file.
-		var confYaml Configuration
-		err = yaml.Unmarshal(content, &confYaml)
+		cwd, _ := os.Getwd()
+		c.workspace, err = confYaml.Builder.ResolveWorkspace(cwd)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrInvalidConfig, configYamlPath, err)
+		}
+
+		// If the container already exists and is up to date, exit.
+		containerJSON, err := c.cli.ContainerInspect(c.ctx, c.containerName)
+
+		if err != nil && !errdefs.IsNotFound(err) {
+			return newDockerError("inspect", err)
+		}
+
+		if containerJSON.ContainerJSONBase != nil {
+			if c.keep || containerJSON.Config.Labels[configHashLabel] == configHash {
+				c.containerID = containerJSON.ID
+				return nil
+			}
+
+			logs.Logger.Info().Msgf("%s changed since container %s was created, "+
+				"recreate it (use --keep to keep the current one)",
+				configYamlPath, c.containerName)
+
+			err = c.cli.ContainerRemove(c.ctx, containerJSON.ID,
+				types.ContainerRemoveOptions{Force: true})
+			if err != nil {
+				return newDockerError("remove "+c.containerName, err)
+			}
+		}
 
 		// Create Mounts
 		mounts := make([]mount.Mount, len(confYaml.Builder.Volumes)+1)
 
 		for i, volume := range confYaml.Builder.Volumes {
-			mounts[i] = mount.Mount{
-				Source:   volume.Source,
-				Target:   volume.Target,
-				ReadOnly: false,
-				Type:     "bind",
-			}
+			mounts[i] = volume.toMount()
 			logs.Logger.Info().Msgf("%s mounted to %s with type %s",
-				volume.Source, volume.Target, volume.Type)
+				mounts[i].Source, mounts[i].Target, mounts[i].Type)
 		}
 
-		cwd, _ := os.Getwd()
-
 		mounts[len(mounts)-1] = mount.Mount{
-			Source:   cwd,
-			Target:   "/app",
+			Source:   c.workspace.Source,
+			Target:   c.workspace.Target,
 			ReadOnly: false,
 			Type:     "bind",
 		}
 		logs.Logger.Info().Msgf("%s mounted to %s with type %s",
-			cwd, "/app", "bind")
+			c.workspace.Source, c.workspace.Target, "bind")
+
+		cacheMounts, err := c.ensureCaches(c.caches)
+		if err != nil {
+			return err
+		}
+		mounts = append(mounts, cacheMounts...)
 
 		logs.Logger.Debug().Msgf("Command: %s", c.cmd)
 
@@ -130,59 +304,264 @@ func WithConfigYaml(configYamlPath string, shell bool) Opt {
 			Env:          c.env,
 			Cmd:          []string{"sleep", "infinity"}, //  Command to run when starting the container
 			Entrypoint:   c.entryPoint,
-			Image:        confYaml.Builder.Image + ":" + confYaml.Builder.Tag,
-			WorkingDir:   "/app",
+			Image:        image,
+			WorkingDir:   c.workspace.Workdir,
+			User:         c.user,
+			Labels:       map[string]string{configHashLabel: configHash},
+		}
+
+		// Already validated by LoadConfiguration.
+		network, _ := confYaml.Builder.parseNetwork()
+		conf.ExposedPorts = network.exposedPorts
+		for port, bindings := range network.hostConfig.PortBindings {
+			for _, binding := range bindings {
+				logs.Logger.Info().Msgf("%s published on %s:%s",
+					port, valueOr(binding.HostIP, "0.0.0.0"), binding.HostPort)
+			}
 		}
-		privileged := false
-		privileged = privileged || confYaml.Builder.Privileged
 
-		confHost := container.HostConfig{Mounts: mounts, Privileged: privileged}
+		security, _ := confYaml.Builder.parseSecurity()
+		resources, shmSize, _ := confYaml.Builder.Resources.parse()
+
+		confHost := network.hostConfig
+		if len(c.services) > 0 {
+			if err := c.ensureNetwork(); err != nil {
+				return err
+			}
+			confHost.NetworkMode = container.NetworkMode(c.networkName())
+		}
+		confHost.Mounts = mounts
+		confHost.Privileged = security.Privileged
+		confHost.CapAdd = security.CapAdd
+		confHost.CapDrop = security.CapDrop
+		confHost.SecurityOpt = security.SecurityOpt
+		confHost.ReadonlyRootfs = security.ReadonlyRootfs
+		confHost.Resources = resources
+		confHost.ShmSize = shmSize
 
 		// Create a new valid container
 		resp, err := c.cli.ContainerCreate(c.ctx, &conf, &confHost, nil, nil, c.containerName)
-
-		switch errorType := err.(type) {
-		case nil:
-			logs.Logger.Info().Msgf("Container %s created with ID=%s",
-				c.containerName,
-				resp.ID[:10],
-			)
-
-		case errdefs.ErrConflict:
-			// If the container already exists, use it.
-			logs.Logger.Info().Msgf("Container %s already exists.", c.containerName)
-			logs.Logger.Error().Err(err).Msgf("Error type is %T", errorType)
-
-		case errdefs.ErrNotFound:
-			logs.Logger.Info().Msgf("Pull %s", conf.Image)
-			reader, _ := c.cli.ImagePull(
-				c.ctx,
-				conf.Image,
-				types.ImagePullOptions{},
-			)
-			io.Copy(os.Stdout, reader)
-			resp, err = c.cli.ContainerCreate(c.ctx, &conf, &confHost, nil, nil, c.containerName)
-
-		default:
-			logs.Logger.Error().Err(err).Msgf("Error type is %T", errorType)
-			panic(err)
+		if err != nil {
+			return newDockerError("create "+c.containerName, err)
 		}
+
+		logs.Logger.Info().Msgf("Container %s created with ID=%s",
+			c.containerName,
+			resp.ID[:10],
+		)
 		c.containerID = resp.ID
+		return nil
 	}
 }
 
-func (c *ContainerManager) Start() {
+func (c *ContainerManager) Start() error {
+	if err := c.startServices(); err != nil {
+		return err
+	}
+
 	logs.Logger.Trace().Msgf("Start container %v", c.containerName)
 
 	err := c.cli.ContainerStart(c.ctx, c.containerName, types.ContainerStartOptions{})
 	if err != nil {
-		panic(err)
+		return newDockerError("start "+c.containerName, err)
+	}
+	if err := c.ensureUser(); err != nil {
+		c.stop()
+		return err
+	}
+	if err := c.chownCaches(); err != nil {
+		c.stop()
+		return err
+	}
+	return nil
+}
+
+// Stop the build container, e.g. to release its published ports. It is not
+// canceled by Interrupt.
+func (c *ContainerManager) stop() {
+	timeout := 1
+	logs.Logger.Info().Msgf("Container %v is stopping", c.containerName)
+	err := c.cli.ContainerStop(context.Background(), c.containerName, container.StopOptions{Timeout: &timeout})
+	if err != nil {
+		logs.Logger.Warn().Err(err).Msgf("Cannot stop container %s", c.containerName)
 	}
 }
 
-func (c *ContainerManager) Exec() {
-	logs.Logger.Trace().Msgf("Exec command %v", c.cmd)
+// Cancel the pending docker calls, then stop the build container and remove
+// the services as StopServices does, e.g. when pauli receives SIGINT.
+func (c *ContainerManager) Interrupt() error {
+	c.cancel()
+	c.stop()
+	return c.StopServices()
+}
+
+// Script creating a passwd entry and a writable HOME for a uid:gid unknown to
+// the image. $1 is the uid and $2 the gid.
+const addUserScript = `
+cut -d: -f3 /etc/passwd | grep -qx "$1" && exit 0
+mkdir -p /home/pauli && chown "$1:$2" /home/pauli
+cut -d: -f3 /etc/group | grep -qx "$2" || echo "pauli:x:$2:" >> /etc/group
+echo "pauli:x:$1:$2:pauli:/home/pauli:/bin/sh" >> /etc/passwd
+`
+
+// Ensure a numeric builder.user has a passwd entry, so that tools relying on
+// the user name or on HOME work.
+func (c *ContainerManager) ensureUser() error {
+	uid, gid, _ := strings.Cut(c.user, ":")
+	if _, err := strconv.Atoi(uid); err != nil {
+		// No user or a user name that must exist in the image.
+		return nil
+	}
+	if gid == "" {
+		gid = uid
+	}
+
+	if c.readOnly {
+		logs.Logger.Warn().Msgf("read_only_rootfs: no passwd entry is created "+
+			"for user %s, it must exist in the image", c.user)
+		return nil
+	}
+
+	exitCode, err := c.execAsRoot([]string{"/bin/sh", "-c", addUserScript, "sh", uid, gid})
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return &DockerError{
+			Op:  "add user " + c.user,
+			Err: fmt.Errorf("exit code %d", exitCode),
+		}
+	}
+	return nil
+}
+
+// Script giving the cache targets "$@" to the user $1, a name or a uid
+// optionally followed by :group. The group of the user is used by default.
+const chownScript = `
+owner=$1; shift
+case "$owner" in
+*:*) ;;
+*) owner="$owner:$(id -g "$owner")" || exit ;;
+esac
+chown "$owner" "$@"
+`
+
+// Give the caches, created owned by root, to builder.user.
+func (c *ContainerManager) chownCaches() error {
+	if c.user == "" || len(c.caches) == 0 {
+		return nil
+	}
+
+	cmd := []string{"/bin/sh", "-c", chownScript, "sh", c.user}
+	for _, cache := range c.caches {
+		cmd = append(cmd, cache.Target)
+	}
+	exitCode, err := c.execAsRoot(cmd)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return &DockerError{
+			Op:  "chown caches to " + c.user,
+			Err: fmt.Errorf("exit code %d", exitCode),
+		}
+	}
+	return nil
+}
+
+// Execute a command as root in the build container, discard its output and
+// return its exit code.
+func (c *ContainerManager) execAsRoot(cmd []string) (int, error) {
+	logs.Logger.Trace().Msgf("Exec as root %v", cmd)
+	return c.execQuiet(types.ExecConfig{Cmd: cmd, User: "0"})
+}
+
+// Execute a command in the build container, discard its output and return
+// its exit code.
+func (c *ContainerManager) execQuiet(config types.ExecConfig) (int, error) {
+	config.AttachStdout = true
+	config.AttachStderr = true
+	exec, err := c.cli.ContainerExecCreate(c.ctx, c.containerName, config)
+	if err != nil {
+		return -1, newDockerError("exec create", err)
+	}
+
+	hijack, err := c.cli.ContainerExecAttach(c.ctx, exec.ID, types.ExecStartCheck{})
+	if err != nil {
+		return -1, newDockerError("exec attach", err)
+	}
+	// The exec is over once its output is consumed.
+	io.Copy(io.Discard, hijack.Reader)
+	hijack.Close()
+
+	return c.execExitCode(exec.ID, nil)
+}
+
+// Time given to the output of an exec to end after the exec.
+const outputGrace = time.Second
+
+// Return the exit code of an exec whose output ended. The exit code is known
+// once docker reports the end of the exec on died. When the daemon does not
+// report it, e.g. died is nil, the exec is inspected less and less often,
+// down to once a second.
+func (c *ContainerManager) execExitCode(execID string, died <-chan struct{}) (int, error) {
+	interval := 10 * time.Millisecond
+	for {
+		execInspect, err := c.cli.ContainerExecInspect(c.ctx, execID)
+		if err != nil {
+			return -1, newDockerError("exec inspect", err)
+		}
+		if !execInspect.Running {
+			return execInspect.ExitCode, nil
+		}
+
+		select {
+		case <-died:
+			// Inspect once more, then only fall back to the interval.
+			died = nil
+		case <-time.After(interval):
+			interval = min(2*interval, time.Second)
+		}
+	}
+}
+
+// Check the credentials against the registry and store them for the next
+// pulls, see SaveRegistryAuth.
+func (c *ContainerManager) Login(auth registry.AuthConfig) error {
+	if _, err := c.cli.RegistryLogin(c.ctx, auth); err != nil {
+		return newDockerError("login "+auth.ServerAddress, err)
+	}
+	return SaveRegistryAuth(auth)
+}
+
+// Execute the command in the build container and return its exit code. The
+// error is only set when docker failed to run the command, a failing task is
+// reported through the exit code. The build container is stopped afterwards,
+// whatever the outcome.
+func (c *ContainerManager) Exec() (int, error) {
+	defer c.stop()
+	return c.exec()
+}
+
+// Execute the command of Exec in the started build container.
+func (c *ContainerManager) exec() (int, error) {
+	cmd := c.cmd
+	if c.task != nil {
+		// pauli.sh is found from the project, whatever the working directory.
+		pauliSh := path.Join(c.workspace.ProjectDir, ".pauli/pauli.sh")
+		cmd = append([]string{"/bin/sh", pauliSh}, c.task...)
+	}
+
+	if err := c.waitForConditions(); err != nil {
+		return -1, err
+	}
+
+	logs.Logger.Trace().Msgf("Exec command %v", cmd)
 	logs.Logger.Trace().Msgf("c.containerID %v", c.containerID)
+
+	// Without a terminal, e.g. in CI, stdout and stderr of the task are kept
+	// apart.
+	tty := term.IsTerminal(os.Stdout.Fd())
 	exec, err := c.cli.ContainerExecCreate(
 		c.ctx,
 		c.containerName,
@@ -190,96 +569,138 @@ func (c *ContainerManager) Exec() {
 			AttachStdin:  false, // makes possible user interaction
 			AttachStdout: true,  // Attach the standard output
 			AttachStderr: true,  // Attach the standard error
-			Tty:          true,
+			Tty:          tty,
 			Env:          c.env,
-			Cmd:          c.cmd, //   Command to run when starting the container
-			WorkingDir:   "/app",
+			Cmd:          cmd, //   Command to run when starting the container
+			WorkingDir:   c.workspace.Workdir,
+			User:         c.user,
 		},
 	)
 
 	if err != nil {
-		panic(err)
+		return -1, newDockerError("exec create", err)
 	}
 
-	hijack, err := c.cli.ContainerExecAttach(c.ctx, exec.ID, types.ExecStartCheck{})
-
-	err = c.cli.ContainerExecStart(c.ctx, exec.ID, types.ExecStartCheck{Detach: false, Tty: false})
+	// Subscribe before the exec starts to not miss its end.
+	eventsCtx, stopEvents := context.WithCancel(c.ctx)
+	defer stopEvents()
+	died := c.execDied(eventsCtx, exec.ID)
 
+	// Attaching starts the exec, its output is the only output of the task.
+	hijack, err := c.cli.ContainerExecAttach(c.ctx, exec.ID, types.ExecStartCheck{Tty: tty})
 	if err != nil {
-		panic(err)
+		return -1, newDockerError("exec attach", err)
 	}
+	defer hijack.Close()
 
+	output := make(chan error, 1)
 	go func() {
-		defer hijack.Conn.Close()
-		io.Copy(os.Stdout, hijack.Reader)
+		output <- copyOutput(c.stdout, c.stderr, hijack.Reader, tty)
 	}()
 
-	go func() {
-		for {
-			execInspect, err := c.cli.ContainerExecInspect(c.ctx, exec.ID)
-			logs.Logger.Trace().Msgf("Exec 'pauli %v' is running=%v", c.cmd[len(c.cmd)-1], execInspect.Running)
-			if err != nil {
-				panic(err)
-			}
+	// Follow the resource usage of the task.
+	monitorCtx, stopMonitor := context.WithCancel(c.ctx)
+	defer stopMonitor()
+	peak := c.monitor(monitorCtx)
 
-			if !execInspect.Running {
-				break
-			}
+	// The exec is over when docker reports its end. The output usually ends
+	// first, but a task may close it and keep running, and a background
+	// process started by the task may keep it open after the end of the exec.
+	outputDone := false
+	var outputErr error
+	select {
+	case outputErr = <-output:
+		outputDone = true
+	case <-died:
+	}
 
-			time.Sleep(1 * time.Second)
-		}
-		timeout := 1
-		c.cli.ContainerStop(c.ctx, c.containerName, container.StopOptions{Timeout: &timeout})
-		logs.Logger.Info().Msgf("Container %v is stopping", c.containerName)
-	}()
+	exitCode, err := c.execExitCode(exec.ID, died)
+	logs.Logger.Debug().Msgf("Exec exited with code %d", exitCode)
 
-	c.DockerLogsToHost()
-}
+	stopMonitor()
+	if c.reportUsage {
+		logs.Logger.Info().Msgf("Peak usage: %s", <-peak)
+	} else {
+		logs.Logger.Debug().Msgf("Peak usage: %s", <-peak)
+	}
 
-// Write docker logs on the host terminal.
-func (c *ContainerManager) DockerLogsToHost() {
-	out, err := c.cli.ContainerLogs(
-		c.ctx,
-		c.containerName,
-		types.ContainerLogsOptions{
-			ShowStdout: true,
-			Follow:     true,
-			Timestamps: true,
-			Since:      "0s",
-		})
-	if err != nil {
-		panic(err)
+	// Write the trailing output, without waiting for background processes
+	// keeping the output open.
+	if !outputDone {
+		select {
+		case outputErr = <-output:
+		case <-time.After(outputGrace):
+			hijack.Close()
+			<-output
+		}
 	}
+	if err == nil && outputErr != nil {
+		err = &DockerError{Op: "exec output", Err: outputErr}
+	}
+	return exitCode, err
+}
+
+// Return a channel closed when docker reports the end of the exec. Nothing
+// is sent if the daemon does not report events, until ctx is canceled.
+func (c *ContainerManager) execDied(ctx context.Context, execID string) <-chan struct{} {
+	died := make(chan struct{})
+
+	// Since replays the events sent while the subscription is established.
+	messages, errs := c.cli.Events(ctx, types.EventsOptions{
+		Since: strconv.FormatInt(time.Now().Unix(), 10),
+		Filters: filters.NewArgs(
+			filters.Arg("type", "container"),
+			filters.Arg("event", "exec_die"),
+			filters.Arg("container", c.containerName),
+		),
+	})
 
 	go func() {
-		defer out.Close()
-		io.Copy(os.Stdout, out)
+		for {
+			select {
+			case message := <-messages:
+				if message.Actor.Attributes["execID"] == execID {
+					close(died)
+					return
+				}
+			case err := <-errs:
+				if err != nil && ctx.Err() == nil {
+					logs.Logger.Debug().Err(err).Msg("Docker events")
+				}
+				return
+			}
+		}
 	}()
+	return died
+}
 
-	statusCh, errCh := c.cli.ContainerWait(
-		c.ctx,
-		c.containerName,
-		container.WaitConditionNotRunning,
-	)
-
-	select {
-	case err := <-errCh:
-		if err != nil {
-			panic(err)
-		}
-	case <-statusCh:
+// Write the output of an exec to stdout and stderr until it ends. Without
+// tty, docker multiplexes stdout and stderr in a single stream.
+func copyOutput(stdout, stderr io.Writer, reader io.Reader, tty bool) error {
+	var err error
+	if tty {
+		_, err = io.Copy(stdout, reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, reader)
 	}
+	return err
 }
 
-// Execute a command on an already existing container.
-func (c *ContainerManager) Shell(shell string) {
-	err := c.cli.ContainerStart(c.ctx, c.containerName, types.ContainerStartOptions{})
-	if err != nil {
-		logs.Logger.Error().Err(err).Msgf("Error type is %T", err)
-		panic(err)
+// Execute an interactive shell on an already existing container and return
+// the exit code of the shell.
+func (c *ContainerManager) Shell(shell string) (int, error) {
+	if err := c.Start(); err != nil {
+		return -1, err
 	}
 
-	args := []string{"docker", "exec", "--privileged", "-ti"}
+	args := []string{"docker", "exec", "-ti"}
+	if c.privileged {
+		args = append(args, "--privileged")
+	}
+	if c.user != "" {
+		args = append(args, "--user", c.user)
+	}
+	args = append(args, "--workdir", c.workspace.Workdir)
 
 	// Add env variables.
 	for i := 0; i < len(c.env); i++ {
@@ -297,10 +718,17 @@ func (c *ContainerManager) Shell(shell string) {
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 
-	err = cmd.Run()
-
-	if err != nil {
-		logs.Logger.Error().Err(err)
+	exitCode := 0
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// The shell returned the exit code of its last command.
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		// docker itself could not run, e.g. not in the PATH.
+		c.stop()
+		return -1, &DockerError{Op: "exec " + shell, Err: err}
 	}
 
 	// Remove container once the interactive session is finished.
@@ -308,6 +736,9 @@ func (c *ContainerManager) Shell(shell string) {
 		c.ctx,
 		c.containerName,
 		container.StopOptions{Signal: "SIGKILL"})
+	if err != nil {
+		return exitCode, newDockerError("stop "+c.containerName, err)
+	}
 	logs.Logger.Info().Msgf("Stop %s container", c.containerName)
-	logs.Logger.Error().Err(err)
+	return exitCode, nil
 }
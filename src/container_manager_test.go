@@ -2,12 +2,23 @@ package src
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/mercierc/pauli/logs"
 	"gopkg.in/yaml.v3"
 	"io/ioutil"
+	"net"
 	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
 // This test the most important Opt. It creates a container from the config.yaml
@@ -45,12 +56,15 @@ func TestNewContainerManager(t *testing.T) {
 	defer file.Close()
 	defer os.Remove(file.Name())
 
-	cm := NewContainerManager(
+	cm, err := NewContainerManager(
 		WithName("pauli_TI"),
 		WithEnv([]string{"VAR1=1", "VAR2=2"}),
 		WithConfigYaml(file.Name(), false),
 		WithCmd([]string{"/bin/sh", ".pauli/pauli.sh"}),
 	)
+	if err != nil {
+		t.Fatalf("Error at container creation: %v", err)
+	}
 
 	// Here we load the information of the created container and ensure
 	// that the config is correct
@@ -98,3 +112,154 @@ func TestNewContainerManager(t *testing.T) {
 	fmt.Println("Cmd ", json.Config.Cmd)
 
 }
+
+// Ensure a missing config.yaml is reported with ErrConfigNotFound.
+func TestNewContainerManagerConfigNotFound(t *testing.T) {
+	_, err := NewContainerManager(
+		WithName("pauli_TI_missing"),
+		WithConfigYaml("/tmp/does_not_exist.yaml", false),
+	)
+
+	if !errors.Is(err, ErrConfigNotFound) {
+		t.Fatalf("Wrong error %v. Waited: %v", err, ErrConfigNotFound)
+	}
+}
+
+// Ensure the stdout and stderr of a task are routed apart without tty.
+func TestCopyOutput(t *testing.T) {
+	var stream bytes.Buffer
+	fmt.Fprint(stdcopy.NewStdWriter(&stream, stdcopy.Stdout), "out\n")
+	fmt.Fprint(stdcopy.NewStdWriter(&stream, stdcopy.Stderr), "err\n")
+
+	var stdout, stderr bytes.Buffer
+	if err := copyOutput(&stdout, &stderr, &stream, false); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Fatalf("Wrong stdout %q or stderr %q", stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	if err := copyOutput(&stdout, &stderr, bytes.NewBufferString("tty\n"), true); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "tty\n" {
+		t.Fatalf("Wrong stdout %q with tty", stdout.String())
+	}
+}
+
+// A docker client running a fake exec. The exec writes output, and ends
+// when die is called.
+type fakeExecClient struct {
+	client.APIClient
+	mu       sync.Mutex
+	running  bool
+	inspects int
+	stopped  bool
+	messages chan events.Message
+	attached chan struct{}
+	server   net.Conn // The exec side of the attached stream.
+}
+
+func newFakeExecClient() *fakeExecClient {
+	return &fakeExecClient{
+		running:  true,
+		messages: make(chan events.Message, 1),
+		attached: make(chan struct{}),
+	}
+}
+
+func (f *fakeExecClient) ContainerExecCreate(context.Context, string, types.ExecConfig) (types.IDResponse, error) {
+	return types.IDResponse{ID: "exec"}, nil
+}
+
+func (f *fakeExecClient) Events(context.Context, types.EventsOptions) (<-chan events.Message, <-chan error) {
+	return f.messages, make(chan error)
+}
+
+func (f *fakeExecClient) ContainerExecAttach(context.Context, string, types.ExecStartCheck) (types.HijackedResponse, error) {
+	conn, server := net.Pipe()
+	f.server = server
+	close(f.attached)
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(conn)}, nil
+}
+
+func (f *fakeExecClient) ContainerStats(context.Context, string, bool) (types.ContainerStats, error) {
+	return types.ContainerStats{}, errors.New("no stats")
+}
+
+func (f *fakeExecClient) ContainerExecInspect(context.Context, string) (types.ContainerExecInspect, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inspects++
+	return types.ContainerExecInspect{Running: f.running, ExitCode: 3}, nil
+}
+
+func (f *fakeExecClient) ContainerStop(context.Context, string, container.StopOptions) error {
+	f.stopped = true
+	return nil
+}
+
+// Write to the stdout of the exec.
+func (f *fakeExecClient) write(s string) {
+	fmt.Fprint(stdcopy.NewStdWriter(f.server, stdcopy.Stdout), s)
+}
+
+// End the exec and report it as docker does.
+func (f *fakeExecClient) die() {
+	f.mu.Lock()
+	f.running = false
+	f.mu.Unlock()
+	f.messages <- events.Message{Actor: events.Actor{Attributes: map[string]string{"execID": "exec"}}}
+}
+
+// Ensure the exit code and the whole output are returned, whether the output
+// ends before or after the end of the exec.
+func TestExecCompletion(t *testing.T) {
+	cases := map[string]func(f *fakeExecClient){
+		"output ends before exec_die": func(f *fakeExecClient) {
+			f.write("task\n")
+			f.server.Close()
+			// The task closed its output and keeps running.
+			time.Sleep(300 * time.Millisecond)
+			f.die()
+		},
+		"output ends after exec_die": func(f *fakeExecClient) {
+			f.die()
+			time.Sleep(100 * time.Millisecond)
+			f.write("task\n")
+			f.server.Close()
+		},
+		"background process keeps the output open": func(f *fakeExecClient) {
+			f.write("task\n")
+			f.die()
+		},
+	}
+
+	for name, run := range cases {
+		f := newFakeExecClient()
+		var stdout bytes.Buffer
+		c := &ContainerManager{cli: f, ctx: context.Background(), cmd: []string{"true"},
+			stdout: &stdout, stderr: &stdout}
+
+		go func() {
+			<-f.attached
+			run(f)
+		}()
+
+		exitCode, err := c.Exec()
+		if err != nil || exitCode != 3 {
+			t.Errorf("%s: wrong exit code %d, %v. Waited: 3", name, exitCode, err)
+		}
+		if stdout.String() != "task\n" {
+			t.Errorf("%s: wrong output %q", name, stdout.String())
+		}
+		// Polling every 10ms would inspect the exec about 30 times.
+		if f.inspects > 10 {
+			t.Errorf("%s: exec inspected %d times", name, f.inspects)
+		}
+		if !f.stopped {
+			t.Errorf("%s: container not stopped", name)
+		}
+	}
+}
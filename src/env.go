@@ -0,0 +1,141 @@
+package src
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Environment variables of builder.env, given either as a map or as a list
+// of KEY=VALUE. A bare KEY, or a KEY without value in the map, takes its
+// value from the host.
+type EnvVars []string
+
+func (e *EnvVars) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var m map[string]*string
+	if err := unmarshal(&m); err == nil {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		*e = EnvVars{}
+		for _, k := range keys {
+			if m[k] == nil {
+				*e = append(*e, k)
+			} else {
+				*e = append(*e, k+"="+*m[k])
+			}
+		}
+		return nil
+	}
+
+	var l []string
+	if err := unmarshal(&l); err != nil {
+		return fmt.Errorf("env must be a map or a list of KEY=VALUE: %w", err)
+	}
+	*e = l
+	return nil
+}
+
+// A list of strings that can also be written as a single string.
+type StringList []string
+
+func (s *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err == nil {
+		*s = StringList{str}
+		return nil
+	}
+
+	var l []string
+	if err := unmarshal(&l); err != nil {
+		return err
+	}
+	*s = l
+	return nil
+}
+
+// Match ${HOST_VAR}.
+var interpolationRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Build the environment of the build container. From the lowest to the
+// highest precedence:
+//  1. the builder.env_file files, in order,
+//  2. builder.env,
+//  3. cliEnv, the --env flags.
+//
+// ${HOST_VAR} in values of env files and builder.env is replaced by the value
+// of HOST_VAR on the host. A bare KEY without = takes the value of KEY on the
+// host and is skipped if KEY is not set. lookup is usually os.LookupEnv.
+func (b Builder) ResolveEnv(cliEnv []string, lookup func(string) (string, bool)) ([]string, error) {
+	var env []string
+
+	for _, path := range b.EnvFile {
+		fileEnv, err := readEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, interpolate(fileEnv, lookup)...)
+	}
+	env = append(env, interpolate(b.Env, lookup)...)
+	env = append(env, cliEnv...)
+
+	// Resolve bare names and let the last definition of a key win.
+	values := map[string]string{}
+	var keys []string
+	for _, entry := range env {
+		key, value, found := strings.Cut(entry, "=")
+		if !found {
+			if value, found = lookup(key); !found {
+				continue
+			}
+		}
+		if _, seen := values[key]; !seen {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+
+	resolved := make([]string, len(keys))
+	for i, key := range keys {
+		resolved[i] = key + "=" + values[key]
+	}
+	return resolved, nil
+}
+
+// Replace ${HOST_VAR} in the values of env.
+func interpolate(env []string, lookup func(string) (string, bool)) []string {
+	interpolated := make([]string, len(env))
+	for i, entry := range env {
+		interpolated[i] = interpolationRegexp.ReplaceAllStringFunc(entry, func(match string) string {
+			value, _ := lookup(match[2 : len(match)-1])
+			return value
+		})
+	}
+	return interpolated
+}
+
+// Read a docker env file: one KEY=VALUE or KEY per line, # starts a comment.
+func readEnvFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("env_file: %w", err)
+	}
+	defer file.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		env = append(env, line)
+	}
+	return env, scanner.Err()
+}
@@ -0,0 +1,66 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Ensure builder.env is read from a map or a list and env_file from a string
+// or a list.
+func TestEnvUnmarshal(t *testing.T) {
+	var fromMap, fromList Builder
+	yaml.Unmarshal([]byte("env:\n  B: 2\n  A: 1\n  C:\nenv_file: .env\n"), &fromMap)
+	yaml.Unmarshal([]byte("env:\n  - B=2\n  - A=1\nenv_file: [a.env, b.env]\n"), &fromList)
+
+	if !slices.Equal(fromMap.Env, EnvVars{"A=1", "B=2", "C"}) ||
+		!slices.Equal(fromMap.EnvFile, StringList{".env"}) {
+		t.Fatalf("Wrong env from map %v %v", fromMap.Env, fromMap.EnvFile)
+	}
+	if !slices.Equal(fromList.Env, EnvVars{"B=2", "A=1"}) ||
+		!slices.Equal(fromList.EnvFile, StringList{"a.env", "b.env"}) {
+		t.Fatalf("Wrong env from list %v %v", fromList.Env, fromList.EnvFile)
+	}
+}
+
+// Ensure the precedence order, the interpolation and the host passthrough.
+func TestResolveEnv(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	os.WriteFile(envFile, []byte("# comment\nFROM_FILE=file\nOVERRIDDEN=file\n\nHOST_ONLY\n"), 0644)
+
+	host := map[string]string{"HOST_ONLY": "host", "USER": "pauli", "FOO": "foo"}
+	lookup := func(key string) (string, bool) {
+		value, ok := host[key]
+		return value, ok
+	}
+
+	builder := Builder{
+		EnvFile: StringList{envFile},
+		Env:     EnvVars{"OVERRIDDEN=config", "HOME_DIR=/home/${USER}", "CLI=config", "UNSET"},
+	}
+
+	env, err := builder.ResolveEnv([]string{"CLI=cli", "FOO"}, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waited := []string{
+		"FROM_FILE=file",
+		"OVERRIDDEN=config",
+		"HOST_ONLY=host",
+		"HOME_DIR=/home/pauli",
+		"CLI=cli",
+		"FOO=foo",
+	}
+	if !slices.Equal(env, waited) {
+		t.Fatalf("Wrong env %v. Waited: %v", env, waited)
+	}
+
+	builder.EnvFile = StringList{"/tmp/does_not_exist.env"}
+	if _, err := builder.ResolveEnv(nil, lookup); err == nil {
+		t.Fatal("Missing env file accepted.")
+	}
+}
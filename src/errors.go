@@ -0,0 +1,55 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// Errors callers can test with errors.Is to know why pauli failed.
+var (
+	ErrConfigNotFound    = errors.New("config file not found")
+	ErrInvalidConfig     = errors.New("invalid config file")
+	ErrDaemonUnreachable = errors.New("docker daemon unreachable")
+	ErrImagePull         = errors.New("image pull failed")
+	ErrImageBuild        = errors.New("image build failed")
+	ErrContainerConflict = errors.New("container conflict")
+	ErrLockMismatch      = errors.New("builder image does not match pauli.lock")
+	ErrProjectExists     = errors.New(".pauli already exists, use --force to overwrite it")
+)
+
+// DockerError is returned when pauli failed to drive docker, as opposed to a
+// task of pauli.sh returning a non zero exit code.
+type DockerError struct {
+	Op   string // Operation that failed, e.g. "exec create".
+	Kind error  // One of the Err* sentinels above, nil if unclassified.
+	Err  error
+}
+
+func (e *DockerError) Error() string {
+	return fmt.Sprintf("docker %s: %v", e.Op, e.Err)
+}
+
+func (e *DockerError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrDaemonUnreachable) and friends work.
+func (e *DockerError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// Wrap an error returned by the docker client and classify it.
+func newDockerError(op string, err error) *DockerError {
+	e := &DockerError{Op: op, Err: err}
+
+	switch {
+	case client.IsErrConnectionFailed(err):
+		e.Kind = ErrDaemonUnreachable
+	case errdefs.IsConflict(err):
+		e.Kind = ErrContainerConflict
+	}
+	return e
+}
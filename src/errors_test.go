@@ -0,0 +1,30 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// Ensure docker errors are classified and still expose the docker error.
+func TestNewDockerError(t *testing.T) {
+	conflict := errdefs.Conflict(fmt.Errorf("name already in use"))
+	err := error(newDockerError("create pauli_TI", conflict))
+
+	if !errors.Is(err, ErrContainerConflict) {
+		t.Fatalf("%v is not a %v", err, ErrContainerConflict)
+	}
+	if errors.Is(err, ErrDaemonUnreachable) {
+		t.Fatalf("%v should not be a %v", err, ErrDaemonUnreachable)
+	}
+	if !errdefs.IsConflict(err) {
+		t.Fatalf("%v does not unwrap to the docker error", err)
+	}
+
+	var dockerErr *DockerError
+	if !errors.As(err, &dockerErr) || dockerErr.Op != "create pauli_TI" {
+		t.Fatalf("%v is not a *DockerError", err)
+	}
+}
@@ -0,0 +1,360 @@
+package src
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/moby/term"
+
+	"github.com/mercierc/pauli/logs"
+)
+
+// builder.build: build the builder image from a Dockerfile of the project.
+type Build struct {
+	Context    string            `yaml:"context"`    // . by default.
+	Dockerfile string            `yaml:"dockerfile"` // Relative to Context, Dockerfile by default.
+	Args       map[string]string `yaml:"args"`
+	Target     string            `yaml:"target"`
+}
+
+// Label holding the hash of the build context an image was built from.
+const buildHashLabel = "pauli.build-hash"
+
+// Characters not allowed in a docker image name.
+var imageNameRegexp = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// Name of the builder image. Without builder.image, a built image is named
+// after the project.
+func (c Configuration) ImageRef() string {
+	image := c.Builder.Image
+	if image == "" && c.Builder.Build != nil {
+		image = "pauli/" + c.imageName()
+	}
+	return image + ":" + valueOr(c.Builder.Tag, "latest")
+}
+
+// The name of the project as a docker image name, empty when no character of
+// the name is valid.
+func (c Configuration) imageName() string {
+	return strings.Trim(imageNameRegexp.ReplaceAllString(strings.ToLower(c.Name), "-"), "-._")
+}
+
+// Hash of the build configuration and of the files of the context.
+func (b Build) Hash() (string, error) {
+	contextDir := valueOr(b.Context, ".")
+	excludes, err := b.excludes(contextDir)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "dockerfile=%s\ntarget=%s\n", b.dockerfile(), b.Target)
+
+	args := make([]string, 0, len(b.Args))
+	for k, v := range b.Args {
+		args = append(args, k+"="+v)
+	}
+	sort.Strings(args)
+	fmt.Fprintf(h, "args=%q\n", args)
+
+	// Modification times are ignored, only names, modes and contents count.
+	err = walkContext(contextDir, excludes, func(rel, path string, info fs.FileInfo) error {
+		fmt.Fprintf(h, "%s %s\n", rel, info.Mode())
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(h, f)
+		return err
+	})
+	return hex.EncodeToString(h.Sum(nil)), err
+}
+
+func (b Build) dockerfile() string {
+	return valueOr(b.Dockerfile, "Dockerfile")
+}
+
+// Patterns of the .dockerignore file of the context. .git and .pauli, which
+// change with every commit and task, are never sent. The Dockerfile and
+// .dockerignore are always sent, as docker does.
+func (b Build) excludes(contextDir string) (*patternmatcher.PatternMatcher, error) {
+	var patterns []string
+
+	f, err := os.Open(filepath.Join(contextDir, ".dockerignore"))
+	if err == nil {
+		defer f.Close()
+		if patterns, err = ignorefile.ReadAll(f); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	patterns = append(patterns, ".git", ".pauli")
+	patterns = append(patterns, "!"+filepath.ToSlash(b.dockerfile()), "!.dockerignore")
+	return patternmatcher.New(patterns)
+}
+
+// Call fn on every file of the context not excluded by .dockerignore, in
+// lexical order. rel is the slash separated path relative to contextDir.
+func walkContext(contextDir string, excludes *patternmatcher.PatternMatcher,
+	fn func(rel, path string, info fs.FileInfo) error) error {
+
+	return filepath.WalkDir(contextDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(contextDir, path)
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		excluded, err := excludes.MatchesOrParentMatches(rel)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			// Excluded folders may contain files included with !pattern.
+			if d.IsDir() && !excludes.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(rel, path, info)
+	})
+}
+
+// Write the context as a tar archive for the docker daemon.
+func tarContext(contextDir string, excludes *patternmatcher.PatternMatcher, w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	err := walkContext(contextDir, excludes, func(rel, path string, info fs.FileInfo) error {
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			var err error
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Build the image from the Dockerfile unless an image built from the same
+// context already exists. Return the hash of the build.
+func (c *ContainerManager) buildImage(build Build, image string) (string, error) {
+	hash, err := build.Hash()
+	if err != nil {
+		return "", fmt.Errorf("%w: build context: %w", ErrImageBuild, err)
+	}
+
+	inspect, _, err := c.cli.ImageInspectWithRaw(c.ctx, image)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return "", newDockerError("image inspect "+image, err)
+	}
+	if err == nil && inspect.Config != nil && inspect.Config.Labels[buildHashLabel] == hash {
+		logs.Logger.Debug().Msgf("Image %s is up to date", image)
+		return hash, nil
+	}
+
+	contextDir := valueOr(build.Context, ".")
+	logs.Logger.Info().Msgf("Build %s from %s",
+		image, filepath.Join(contextDir, build.dockerfile()))
+
+	excludes, err := build.excludes(contextDir)
+	if err != nil {
+		return "", fmt.Errorf("%w: build context: %w", ErrImageBuild, err)
+	}
+
+	// Stream the context to the daemon while it is archived.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(tarContext(contextDir, excludes, pw))
+	}()
+	defer pr.Close()
+
+	args := map[string]*string{}
+	for k, v := range build.Args {
+		v := v
+		args[k] = &v
+	}
+
+	resp, err := c.cli.ImageBuild(c.ctx, pr, types.ImageBuildOptions{
+		Tags:        []string{image},
+		Dockerfile:  filepath.ToSlash(build.dockerfile()),
+		BuildArgs:   args,
+		Target:      build.Target,
+		Labels:      map[string]string{buildHashLabel: hash},
+		Remove:      true,
+		ForceRemove: true,
+	})
+	if err != nil {
+		return "", &DockerError{Op: "build " + image, Kind: ErrImageBuild, Err: err}
+	}
+	defer resp.Body.Close()
+
+	// Render the build output, a failing step is returned as an error.
+	fd, isTerminal := term.GetFdInfo(os.Stderr)
+	err = jsonmessage.DisplayJSONMessagesStream(resp.Body, os.Stderr, fd, isTerminal, nil)
+	if err != nil {
+		return "", &DockerError{Op: "build " + image, Kind: ErrImageBuild, Err: err}
+	}
+	return hash, nil
+}
+
+// Pull policies of builder.pull.
+const (
+	PullAlways       = "always"
+	PullIfNotPresent = "if-not-present"
+	PullNever        = "never"
+)
+
+var pullPolicies = []string{PullAlways, PullIfNotPresent, PullNever}
+
+// Ensure image is present according to the pull policy and return its ID.
+func (c *ContainerManager) ensureImage(image, policy string) (string, error) {
+	if policy == PullAlways {
+		if err := c.pullImage(image); err != nil {
+			return "", err
+		}
+	}
+
+	inspect, _, err := c.cli.ImageInspectWithRaw(c.ctx, image)
+	if errdefs.IsNotFound(err) {
+		if policy == PullNever {
+			return "", &DockerError{
+				Op:   "pull " + image,
+				Kind: ErrImagePull,
+				Err:  fmt.Errorf("image not present and pull policy is %s", PullNever),
+			}
+		}
+		if err = c.pullImage(image); err != nil {
+			return "", err
+		}
+		inspect, _, err = c.cli.ImageInspectWithRaw(c.ctx, image)
+	}
+	if err != nil {
+		return "", newDockerError("image inspect "+image, err)
+	}
+	return inspect.ID, nil
+}
+
+// Pull the builder image of config.yaml, or its digest pinned in pauli.lock.
+func (c *ContainerManager) Pull(configYamlPath string) error {
+	confYaml, err := LoadConfiguration(configYamlPath)
+	if err != nil {
+		return err
+	}
+	if confYaml.Builder.Build != nil {
+		logs.Logger.Info().Msg("The builder image is built from a Dockerfile, nothing to pull")
+		return nil
+	}
+
+	c.registry, c.builderImage = confYaml.Builder.Registry, confYaml.ImageRef()
+
+	image, err := c.lockedImage(LockPath(configYamlPath), confYaml.ImageRef())
+	if err != nil {
+		return err
+	}
+	return c.pullImage(image)
+}
+
+// Pull an image from its registry.
+func (c *ContainerManager) pullImage(image string) error {
+	logs.Logger.Info().Msgf("Pull %s", image)
+	registryAuth, err := encodedRegistryAuth(image, c.builderImage, c.registry)
+	if err != nil {
+		return &DockerError{Op: "pull " + image, Kind: ErrImagePull, Err: err}
+	}
+
+	reader, err := c.cli.ImagePull(c.ctx, image, types.ImagePullOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return &DockerError{Op: "pull " + image, Kind: ErrImagePull, Err: err}
+	}
+	defer reader.Close()
+
+	if err = renderPull(reader); err != nil {
+		return &DockerError{Op: "pull " + image, Kind: ErrImagePull, Err: err}
+	}
+	return nil
+}
+
+// Render the json progress stream of a pull on stderr: progress bars on a
+// terminal, one log line per layer otherwise, e.g. in CI.
+func renderPull(reader io.Reader) error {
+	fd, isTerminal := term.GetFdInfo(os.Stderr)
+	if isTerminal {
+		return jsonmessage.DisplayJSONMessagesStream(reader, os.Stderr, fd, isTerminal, nil)
+	}
+
+	decoder := json.NewDecoder(reader)
+	for {
+		var jm jsonmessage.JSONMessage
+		if err := decoder.Decode(&jm); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if jm.Error != nil {
+			return jm.Error
+		}
+
+		switch {
+		case jm.ID == "" && jm.Status != "":
+			// Digest and final status.
+			logs.Logger.Info().Msg(jm.Status)
+		case jm.Status == "Pull complete" || jm.Status == "Already exists" ||
+			strings.HasPrefix(jm.Status, "Pulling from"):
+			logs.Logger.Info().Msgf("%s: %s", jm.ID, jm.Status)
+		}
+	}
+}
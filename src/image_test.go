@@ -0,0 +1,136 @@
+package src
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Ensure the build hash follows the context, except the files excluded by
+// .dockerignore.
+func TestBuildHash(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "Dockerfile.build"), []byte("FROM golang:1.22\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("bin\n"), 0644)
+	os.MkdirAll(filepath.Join(dir, "bin"), 0755)
+
+	build := Build{Context: dir, Dockerfile: "Dockerfile.build"}
+	hash, err := build.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "bin", "app"), []byte("binary"), 0755)
+	if h, _ := build.Hash(); h != hash {
+		t.Fatal("The hash changed with an excluded file.")
+	}
+
+	build.Args = map[string]string{"GO_VERSION": "1.22"}
+	if h, _ := build.Hash(); h == hash {
+		t.Fatal("The hash did not change with the build args.")
+	}
+	hash, _ = build.Hash()
+
+	os.WriteFile(filepath.Join(dir, "Dockerfile.build"), []byte("FROM golang:1.21\n"), 0644)
+	if h, _ := build.Hash(); h == hash {
+		t.Fatal("The hash did not change with the Dockerfile.")
+	}
+
+	// Check the archive sent to docker.
+	excludes, _ := build.excludes(dir)
+	var buf bytes.Buffer
+	if err := tarContext(dir, excludes, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(&buf)
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		names = append(names, header.Name)
+	}
+	waited := []string{".dockerignore", "Dockerfile.build"}
+	if !slices.Equal(names, waited) {
+		t.Fatalf("Wrong context %v. Waited: %v", names, waited)
+	}
+}
+
+// Ensure the outputs of git and of the tasks do not trigger a rebuild, even
+// without .dockerignore, while a Dockerfile in .pauli is still sent.
+func TestBuildHashIgnoresOutputs(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, ".pauli"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	os.WriteFile(filepath.Join(dir, ".pauli", "Dockerfile"), []byte("FROM golang:1.22\n"), 0644)
+
+	build := Build{Context: dir, Dockerfile: ".pauli/Dockerfile"}
+	hash, err := build.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(filepath.Join(dir, ".git", "index"), []byte("commit"), 0644)
+	os.WriteFile(filepath.Join(dir, ".pauli", "coverage.out"), []byte("mode: set"), 0644)
+	if h, _ := build.Hash(); h != hash {
+		t.Fatal("The hash changed with an output of git or of a task.")
+	}
+
+	os.WriteFile(filepath.Join(dir, "app"), []byte("binary"), 0755)
+	if h, _ := build.Hash(); h == hash {
+		t.Fatal("The hash did not change with a file of the context.")
+	}
+
+	excludes, _ := build.excludes(dir)
+	var buf bytes.Buffer
+	if err := tarContext(dir, excludes, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(&buf)
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		names = append(names, header.Name)
+	}
+	waited := []string{".pauli/Dockerfile", "app"}
+	if !slices.Equal(names, waited) {
+		t.Fatalf("Wrong context %v. Waited: %v", names, waited)
+	}
+}
+
+// Ensure a built image without builder.image is named after the project.
+func TestImageRef(t *testing.T) {
+	conf := Configuration{Name: "My Project", Builder: Builder{Build: &Build{}}}
+	if ref := conf.ImageRef(); ref != "pauli/my-project:latest" {
+		t.Fatalf("Wrong image %s. Waited: pauli/my-project:latest", ref)
+	}
+
+	conf.Name = "__"
+	if err := conf.Validate(); err == nil {
+		t.Fatal("A built image without name is valid.")
+	}
+
+	conf.Builder.Image, conf.Builder.Tag = "golang", "1.22"
+	if ref := conf.ImageRef(); ref != "golang:1.22" {
+		t.Fatalf("Wrong image %s. Waited: golang:1.22", ref)
+	}
+}
+
+// Ensure errors of the pull stream are returned.
+func TestRenderPull(t *testing.T) {
+	stream := `{"status":"Pulling from library/golang","id":"alpine"}
+{"status":"Downloading","progressDetail":{"current":1,"total":2},"id":"abc"}
+{"status":"Pull complete","id":"abc"}
+{"status":"Status: Downloaded newer image for golang:alpine"}
+`
+	if err := renderPull(strings.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+
+	stream += `{"errorDetail":{"message":"manifest unknown"},"error":"manifest unknown"}` + "\n"
+	if err := renderPull(strings.NewReader(stream)); err == nil || err.Error() != "manifest unknown" {
+		t.Fatalf("Wrong error %v. Waited: manifest unknown", err)
+	}
+}
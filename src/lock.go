@@ -0,0 +1,143 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/errdefs"
+	"gopkg.in/yaml.v2"
+
+	"github.com/mercierc/pauli/logs"
+)
+
+// Content of .pauli/pauli.lock: the builder image of config.yaml pinned to
+// its content digest.
+type Lock struct {
+	Image  string `yaml:"image"`  // e.g. golang:alpine
+	Digest string `yaml:"digest"` // e.g. golang@sha256:...
+}
+
+// Path of the pauli.lock file next to config.yaml.
+func LockPath(configYamlPath string) string {
+	return filepath.Join(filepath.Dir(configYamlPath), "pauli.lock")
+}
+
+// Read a pauli.lock file. Return nil if it does not exist.
+func LoadLock(lockPath string) (*Lock, error) {
+	content, err := os.ReadFile(lockPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lock Lock
+	if err = yaml.Unmarshal(content, &lock); err != nil {
+		return nil, fmt.Errorf("%s: %w", lockPath, err)
+	}
+	return &lock, nil
+}
+
+// Write the pauli.lock file.
+func (l Lock) Save(lockPath string) error {
+	content, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	header := "# Generated by pauli lock, do not edit.\n"
+	return os.WriteFile(lockPath, append([]byte(header), content...), 0644)
+}
+
+// Return the digest of repoDigests (e.g. golang@sha256:...) belonging to the
+// repository of image, or an empty string.
+func matchDigest(image string, repoDigests []string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return ""
+	}
+
+	for _, repoDigest := range repoDigests {
+		digested, err := reference.ParseNormalizedNamed(repoDigest)
+		if err == nil && digested.Name() == named.Name() {
+			return repoDigest
+		}
+	}
+	return ""
+}
+
+// Pull the builder image of config.yaml and pin it to its digest in
+// pauli.lock.
+func (c *ContainerManager) Lock(configYamlPath string) (Lock, error) {
+	confYaml, err := LoadConfiguration(configYamlPath)
+	if err != nil {
+		return Lock{}, err
+	}
+	if confYaml.Builder.Build != nil {
+		return Lock{}, fmt.Errorf("%w: the builder image is built from a Dockerfile, "+
+			"pin the base images in the Dockerfile instead", ErrInvalidConfig)
+	}
+
+	c.registry, c.builderImage = confYaml.Builder.Registry, confYaml.ImageRef()
+	lock := Lock{Image: confYaml.ImageRef()}
+	if err = c.pullImage(lock.Image); err != nil {
+		return lock, err
+	}
+
+	inspect, _, err := c.cli.ImageInspectWithRaw(c.ctx, lock.Image)
+	if err != nil {
+		return lock, newDockerError("image inspect "+lock.Image, err)
+	}
+
+	if lock.Digest = matchDigest(lock.Image, inspect.RepoDigests); lock.Digest == "" {
+		return lock, fmt.Errorf("no digest for %s, is it pushed to a registry?", lock.Image)
+	}
+
+	return lock, lock.Save(LockPath(configYamlPath))
+}
+
+// Return the image to use for image: its digest in pauli.lock, or image
+// itself without lock. Warn, or fail when frozen, if the lock is missing, is
+// outdated or if the local image differs from the lock.
+func (c *ContainerManager) lockedImage(lockPath, image string) (string, error) {
+	mismatch := func(msg string, args ...interface{}) (string, error) {
+		err := fmt.Errorf("%w: %s", ErrLockMismatch, fmt.Sprintf(msg, args...))
+		if c.frozen {
+			return "", err
+		}
+		logs.Logger.Warn().Msg(err.Error())
+		return image, nil
+	}
+
+	lock, err := LoadLock(lockPath)
+	if err != nil {
+		return "", err
+	}
+	if lock == nil {
+		if c.frozen {
+			return mismatch("%s does not exist, run pauli lock", lockPath)
+		}
+		return image, nil
+	}
+
+	if lock.Image != image {
+		return mismatch("%s pins %s but config.yaml uses %s, run pauli lock",
+			lockPath, lock.Image, image)
+	}
+
+	inspect, _, err := c.cli.ImageInspectWithRaw(c.ctx, image)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return "", newDockerError("image inspect "+image, err)
+	}
+	if err == nil && matchDigest(image, inspect.RepoDigests) != lock.Digest {
+		if _, err := mismatch("local %s differs from %s pinned in %s",
+			image, lock.Digest, lockPath); err != nil {
+			return "", err
+		}
+	}
+
+	logs.Logger.Debug().Msgf("Use %s pinned in %s", lock.Digest, lockPath)
+	return lock.Digest, nil
+}
@@ -0,0 +1,47 @@
+package src
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Ensure pauli.lock is written and read back.
+func TestLockSaveLoad(t *testing.T) {
+	lockPath := LockPath(filepath.Join(t.TempDir(), "config.yaml"))
+
+	if lock, err := LoadLock(lockPath); lock != nil || err != nil {
+		t.Fatalf("Missing lock loaded: %v, %v", lock, err)
+	}
+
+	lock := Lock{Image: "golang:alpine", Digest: "golang@sha256:0123"}
+	if err := lock.Save(lockPath); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadLock(lockPath)
+	if err != nil || *loaded != lock {
+		t.Fatalf("Wrong lock %v, %v. Waited: %v", loaded, err, lock)
+	}
+}
+
+// Ensure the digest of the image repository is chosen.
+func TestMatchDigest(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	repoDigests := []string{
+		"registry.local:5000/golang@" + digest,
+		"golang@" + digest,
+	}
+
+	if d := matchDigest("golang:alpine", repoDigests); d != "golang@"+digest {
+		t.Fatalf("Wrong digest %s", d)
+	}
+	if d := matchDigest("docker.io/library/golang:alpine", repoDigests); d != "golang@"+digest {
+		t.Fatalf("Wrong digest %s", d)
+	}
+	if d := matchDigest("registry.local:5000/golang:alpine", repoDigests); d != repoDigests[0] {
+		t.Fatalf("Wrong digest %s", d)
+	}
+	if d := matchDigest("node:20", repoDigests); d != "" {
+		t.Fatalf("Wrong digest %s", d)
+	}
+}
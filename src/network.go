@@ -0,0 +1,58 @@
+package src
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+// Network modes handled by docker, any other builder.network is the name of
+// an existing docker network.
+var builtinNetworks = []string{"bridge", "host", "none"}
+
+// Network settings of the build container.
+type networkSettings struct {
+	exposedPorts nat.PortSet
+	hostConfig   container.HostConfig // Only the network fields are set.
+}
+
+// Convert builder.network, builder.ports, builder.extra_hosts and
+// builder.dns to docker settings.
+func (b Builder) parseNetwork() (networkSettings, error) {
+	var s networkSettings
+
+	if strings.ContainsAny(b.Network, " /") {
+		return s, fmt.Errorf("network: invalid network name %q", b.Network)
+	}
+	s.hostConfig.NetworkMode = container.NetworkMode(valueOr(b.Network, "bridge"))
+
+	if len(b.Ports) > 0 && (b.Network == "host" || b.Network == "none") {
+		return s, fmt.Errorf("ports: ports can't be published with the %s network", b.Network)
+	}
+
+	var err error
+	s.exposedPorts, s.hostConfig.PortBindings, err = nat.ParsePortSpecs(b.Ports)
+	if err != nil {
+		return s, fmt.Errorf("ports: %w", err)
+	}
+
+	for _, host := range b.ExtraHosts {
+		// IPv6 addresses contain colons, the host name does not.
+		name, ip, _ := strings.Cut(host, ":")
+		if name == "" || (ip != "host-gateway" && net.ParseIP(ip) == nil) {
+			return s, fmt.Errorf("extra_hosts: invalid host %s, waited name:ip", host)
+		}
+	}
+	s.hostConfig.ExtraHosts = b.ExtraHosts
+
+	for _, server := range b.DNS {
+		if net.ParseIP(server) == nil {
+			return s, fmt.Errorf("dns: invalid ip address %s", server)
+		}
+	}
+	s.hostConfig.DNS = b.DNS
+	return s, nil
+}
@@ -0,0 +1,49 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// Ensure the network settings are converted and invalid ones refused.
+func TestParseNetwork(t *testing.T) {
+	b := Builder{
+		Ports:      StringList{"8080:80", "127.0.0.1:5353:53/udp"},
+		ExtraHosts: StringList{"db:10.0.0.2", "host.docker.internal:host-gateway", "v6:::1"},
+		DNS:        StringList{"1.1.1.1"},
+	}
+
+	s, err := b.parseNetwork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.hostConfig.NetworkMode != "bridge" {
+		t.Fatalf("Wrong network %s. Waited: bridge", s.hostConfig.NetworkMode)
+	}
+	if _, ok := s.exposedPorts[nat.Port("53/udp")]; !ok || len(s.exposedPorts) != 2 {
+		t.Fatalf("Wrong exposed ports %v", s.exposedPorts)
+	}
+	binding := s.hostConfig.PortBindings[nat.Port("80/tcp")]
+	if len(binding) != 1 || binding[0].HostPort != "8080" {
+		t.Fatalf("Wrong port bindings %v", s.hostConfig.PortBindings)
+	}
+	if len(s.hostConfig.ExtraHosts) != 3 || len(s.hostConfig.DNS) != 1 {
+		t.Fatalf("Wrong hosts %v or dns %v", s.hostConfig.ExtraHosts, s.hostConfig.DNS)
+	}
+
+	invalid := []Builder{
+		{Network: "host", Ports: StringList{"8080:80"}},
+		{Network: "none", Ports: StringList{"8080:80"}},
+		{Network: "my network"},
+		{Ports: StringList{"http"}},
+		{ExtraHosts: StringList{"db"}},
+		{ExtraHosts: StringList{"db:localhost"}},
+		{DNS: StringList{"dns.google"}},
+	}
+	for _, b := range invalid {
+		if _, err := b.parseNetwork(); err == nil {
+			t.Errorf("%+v should be invalid", b)
+		}
+	}
+}
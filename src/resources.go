@@ -0,0 +1,165 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+
+	"github.com/mercierc/pauli/logs"
+)
+
+// builder.resources: limits of the build container. Sizes accept docker
+// units, e.g. 512m or 4g.
+type Resources struct {
+	CPUs       string `yaml:"cpus"`        // Number of CPUs, e.g. 1.5.
+	Memory     string `yaml:"memory"`      // e.g. 4g.
+	MemorySwap string `yaml:"memory_swap"` // Memory plus swap, -1 for unlimited swap.
+	PidsLimit  int64  `yaml:"pids_limit"`
+	ShmSize    string `yaml:"shm_size"` // Size of /dev/shm, e.g. 1g.
+	// Soft and hard limits, e.g. nofile: 1024:4096, or a single value for
+	// both.
+	Ulimits map[string]string `yaml:"ulimits"`
+}
+
+// Whether at least one limit is set.
+func (r Resources) isSet() bool {
+	return r.CPUs != "" || r.Memory != "" || r.MemorySwap != "" ||
+		r.PidsLimit != 0 || r.ShmSize != "" || len(r.Ulimits) > 0
+}
+
+// Convert the resources to docker resources and the size of /dev/shm.
+func (r Resources) parse() (container.Resources, int64, error) {
+	var resources container.Resources
+	var shmSize int64
+
+	if r.CPUs != "" {
+		cpus, err := strconv.ParseFloat(r.CPUs, 64)
+		if err != nil || cpus <= 0 {
+			return resources, 0, fmt.Errorf("resources.cpus: invalid number of CPUs %s", r.CPUs)
+		}
+		resources.NanoCPUs = int64(cpus * 1e9)
+	}
+
+	var err error
+	if r.Memory != "" {
+		if resources.Memory, err = units.RAMInBytes(r.Memory); err != nil {
+			return resources, 0, fmt.Errorf("resources.memory: %w", err)
+		}
+	}
+
+	if r.MemorySwap == "-1" {
+		resources.MemorySwap = -1
+	} else if r.MemorySwap != "" {
+		if resources.MemorySwap, err = units.RAMInBytes(r.MemorySwap); err != nil {
+			return resources, 0, fmt.Errorf("resources.memory_swap: %w", err)
+		}
+		if resources.Memory == 0 || resources.MemorySwap < resources.Memory {
+			return resources, 0, fmt.Errorf("resources.memory_swap must be greater " +
+				"than resources.memory, it includes the memory")
+		}
+	}
+
+	if r.PidsLimit < 0 {
+		return resources, 0, fmt.Errorf("resources.pids_limit must be positive")
+	}
+	if r.PidsLimit > 0 {
+		resources.PidsLimit = &r.PidsLimit
+	}
+
+	if r.ShmSize != "" {
+		if shmSize, err = units.RAMInBytes(r.ShmSize); err != nil {
+			return resources, 0, fmt.Errorf("resources.shm_size: %w", err)
+		}
+	}
+
+	// Sort ulimits to keep the container configuration stable.
+	names := make([]string, 0, len(r.Ulimits))
+	for name := range r.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ulimit, err := units.ParseUlimit(name + "=" + r.Ulimits[name])
+		if err != nil {
+			return resources, 0, fmt.Errorf("resources.ulimits: %w", err)
+		}
+		resources.Ulimits = append(resources.Ulimits, ulimit)
+	}
+	return resources, shmSize, nil
+}
+
+// Peak usage of the build container during a task.
+type usagePeak struct {
+	memory      uint64  // Bytes, without the page cache.
+	memoryLimit uint64  // Bytes.
+	cpu         float64 // Percent of one CPU.
+	pids        uint64
+}
+
+// Update the peak with a stats sample of docker.
+func (p *usagePeak) update(stats types.StatsJSON) {
+	// The page cache is not counted, as docker stats does.
+	memory := stats.MemoryStats.Usage
+	cache := stats.MemoryStats.Stats["total_inactive_file"]    // cgroup v1
+	if v, ok := stats.MemoryStats.Stats["inactive_file"]; ok { // cgroup v2
+		cache = v
+	}
+	if cache < memory {
+		memory -= cache
+	}
+	p.memory = max(p.memory, memory)
+	p.memoryLimit = stats.MemoryStats.Limit
+
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		onlineCPUs := float64(stats.CPUStats.OnlineCPUs)
+		if onlineCPUs == 0 {
+			onlineCPUs = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+		}
+		p.cpu = max(p.cpu, cpuDelta/systemDelta*onlineCPUs*100)
+	}
+
+	p.pids = max(p.pids, stats.PidsStats.Current)
+}
+
+func (p usagePeak) String() string {
+	return fmt.Sprintf("memory %s / %s, cpu %.0f%%, pids %d",
+		units.BytesSize(float64(p.memory)), units.BytesSize(float64(p.memoryLimit)),
+		p.cpu, p.pids)
+}
+
+// Follow the stats of the build container until ctx is canceled and return
+// the peak usage on the channel.
+func (c *ContainerManager) monitor(ctx context.Context) <-chan usagePeak {
+	peak := make(chan usagePeak, 1)
+
+	go func() {
+		var p usagePeak
+		defer func() { peak <- p }()
+
+		stats, err := c.cli.ContainerStats(ctx, c.containerName, true)
+		if err != nil {
+			logs.Logger.Debug().Err(err).Msg("Cannot follow the container stats")
+			return
+		}
+		defer stats.Body.Close()
+
+		decoder := json.NewDecoder(stats.Body)
+		for {
+			var sample types.StatsJSON
+			if err := decoder.Decode(&sample); err != nil {
+				return
+			}
+			p.update(sample)
+		}
+	}()
+	return peak
+}
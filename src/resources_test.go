@@ -0,0 +1,74 @@
+package src
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Ensure units are converted and invalid values refused.
+func TestResourcesParse(t *testing.T) {
+	r := Resources{
+		CPUs:       "1.5",
+		Memory:     "4g",
+		MemorySwap: "6g",
+		PidsLimit:  512,
+		ShmSize:    "1g",
+		Ulimits:    map[string]string{"nproc": "2048", "nofile": "1024:4096"},
+	}
+
+	resources, shmSize, err := r.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources.NanoCPUs != 1500000000 || resources.Memory != 4<<30 ||
+		resources.MemorySwap != 6<<30 || *resources.PidsLimit != 512 || shmSize != 1<<30 {
+		t.Fatalf("Wrong resources %+v, shm %d", resources, shmSize)
+	}
+	if len(resources.Ulimits) != 2 || resources.Ulimits[0].Name != "nofile" ||
+		resources.Ulimits[0].Soft != 1024 || resources.Ulimits[0].Hard != 4096 ||
+		resources.Ulimits[1].Soft != 2048 || resources.Ulimits[1].Hard != 2048 {
+		t.Fatalf("Wrong ulimits %+v %+v", resources.Ulimits[0], resources.Ulimits[1])
+	}
+
+	invalid := []Resources{
+		{CPUs: "two"},
+		{CPUs: "-1"},
+		{Memory: "4 apples"},
+		{Memory: "4g", MemorySwap: "2g"},
+		{ShmSize: "big"},
+		{PidsLimit: -1},
+		{Ulimits: map[string]string{"nofile": "many"}},
+	}
+	for _, r := range invalid {
+		if _, _, err := r.parse(); err == nil {
+			t.Errorf("%+v should be invalid", r)
+		}
+	}
+}
+
+// Ensure the peak usage keeps the maximum of the samples.
+func TestUsagePeak(t *testing.T) {
+	var p usagePeak
+
+	sample := types.StatsJSON{}
+	sample.MemoryStats.Usage = 300 << 20
+	sample.MemoryStats.Stats = map[string]uint64{"inactive_file": 100 << 20}
+	sample.MemoryStats.Limit = 1 << 30
+	sample.PreCPUStats.CPUUsage.TotalUsage = 0
+	sample.CPUStats.CPUUsage.TotalUsage = 150
+	sample.PreCPUStats.SystemUsage = 0
+	sample.CPUStats.SystemUsage = 400
+	sample.CPUStats.OnlineCPUs = 4
+	sample.PidsStats.Current = 12
+	p.update(sample)
+
+	sample.MemoryStats.Usage = 150 << 20
+	sample.CPUStats.CPUUsage.TotalUsage = 10
+	sample.PidsStats.Current = 3
+	p.update(sample)
+
+	if p.memory != 200<<20 || p.cpu != 150 || p.pids != 12 {
+		t.Fatalf("Wrong peak %+v", p)
+	}
+}
@@ -0,0 +1,46 @@
+package src
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Capability names, with or without the CAP_ prefix, or ALL.
+var capabilityRegexp = regexp.MustCompile(`^[A-Za-z_]+$`)
+
+// Convert builder.cap_add, builder.cap_drop, builder.security_opt,
+// builder.read_only_rootfs and builder.no_new_privileges to docker settings.
+// Only the security fields of the returned host config are set.
+func (b Builder) parseSecurity() (container.HostConfig, error) {
+	h := container.HostConfig{
+		Privileged:     b.Privileged,
+		ReadonlyRootfs: b.ReadOnlyRootfs,
+	}
+
+	if b.Privileged && (len(b.CapAdd) > 0 || len(b.CapDrop) > 0) {
+		return h, fmt.Errorf("cap_add and cap_drop have no effect with privileged")
+	}
+
+	for _, capability := range append(append([]string{}, b.CapAdd...), b.CapDrop...) {
+		if !capabilityRegexp.MatchString(capability) {
+			return h, fmt.Errorf("invalid capability %s, e.g. SYS_PTRACE or ALL", capability)
+		}
+	}
+	h.CapAdd = []string(b.CapAdd)
+	h.CapDrop = []string(b.CapDrop)
+
+	for _, opt := range b.SecurityOpt {
+		// Docker also accepts the legacy key:value format.
+		if opt != "no-new-privileges" && !strings.ContainsAny(opt, "=:") {
+			return h, fmt.Errorf("security_opt: invalid option %s, waited key=value", opt)
+		}
+		h.SecurityOpt = append(h.SecurityOpt, opt)
+	}
+	if b.NoNewPrivileges {
+		h.SecurityOpt = append(h.SecurityOpt, "no-new-privileges:true")
+	}
+	return h, nil
+}
@@ -0,0 +1,36 @@
+package src
+
+import "testing"
+
+// Ensure the security options are converted and invalid ones refused.
+func TestParseSecurity(t *testing.T) {
+	b := Builder{
+		CapAdd:          StringList{"SYS_PTRACE"},
+		CapDrop:         StringList{"ALL"},
+		SecurityOpt:     StringList{"seccomp=unconfined"},
+		ReadOnlyRootfs:  true,
+		NoNewPrivileges: true,
+	}
+
+	h, err := b.parseSecurity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Privileged || !h.ReadonlyRootfs || len(h.CapAdd) != 1 || len(h.CapDrop) != 1 {
+		t.Fatalf("Wrong security settings %+v", h)
+	}
+	if len(h.SecurityOpt) != 2 || h.SecurityOpt[1] != "no-new-privileges:true" {
+		t.Fatalf("Wrong security options %v", h.SecurityOpt)
+	}
+
+	invalid := []Builder{
+		{Privileged: true, CapAdd: StringList{"SYS_ADMIN"}},
+		{CapAdd: StringList{"SYS PTRACE"}},
+		{SecurityOpt: StringList{"unconfined"}},
+	}
+	for _, b := range invalid {
+		if _, err := b.parseSecurity(); err == nil {
+			t.Errorf("%+v should be invalid", b)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/mercierc/pauli/logs"
+)
+
+// A sidecar container, e.g. a database, started before the tasks on the
+// network of the project. The build container reaches it by its name.
+type Service struct {
+	Image       string       `yaml:"image"` // With its tag, e.g. postgres:16.
+	Env         EnvVars      `yaml:"env"`
+	Ports       StringList   `yaml:"ports"` // Published on the host, as docker run -p.
+	Healthcheck *Healthcheck `yaml:"healthcheck"`
+	Volumes     []Volume     `yaml:"volumes"`
+}
+
+// Command telling whether a service is ready, as the HEALTHCHECK of a
+// Dockerfile. A single string is run by the shell of the service.
+type Healthcheck struct {
+	Test        StringList `yaml:"test"`
+	Interval    string     `yaml:"interval"` // Durations, e.g. 2s.
+	Timeout     string     `yaml:"timeout"`
+	StartPeriod string     `yaml:"start_period"`
+	Retries     int        `yaml:"retries"`
+}
+
+// Service names are host names on the network of the project.
+var serviceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Ensure the service named name can be started.
+func (s Service) Validate(name string) error {
+	if !serviceNameRegexp.MatchString(name) {
+		return fmt.Errorf("service %s: invalid name", name)
+	}
+	if s.Image == "" {
+		return fmt.Errorf("service %s: image is missing", name)
+	}
+	if _, _, err := nat.ParsePortSpecs(s.Ports); err != nil {
+		return fmt.Errorf("service %s: ports: %w", name, err)
+	}
+	if _, err := s.Healthcheck.toHealthConfig(); err != nil {
+		return fmt.Errorf("service %s: healthcheck: %w", name, err)
+	}
+	for _, volume := range s.Volumes {
+		if err := volume.Validate(); err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Convert the healthcheck to its docker counterpart, nil to use the
+// HEALTHCHECK of the image.
+func (h *Healthcheck) toHealthConfig() (*container.HealthConfig, error) {
+	if h == nil {
+		return nil, nil
+	}
+
+	health := &container.HealthConfig{Retries: h.Retries}
+	switch {
+	case len(h.Test) == 0:
+		return nil, fmt.Errorf("test is missing")
+	case len(h.Test) == 1:
+		health.Test = []string{"CMD-SHELL", h.Test[0]}
+	case h.Test[0] == "CMD" || h.Test[0] == "CMD-SHELL":
+		health.Test = h.Test
+	default:
+		health.Test = append([]string{"CMD"}, h.Test...)
+	}
+
+	durations := []struct {
+		value string
+		out   *time.Duration
+	}{
+		{h.Interval, &health.Interval},
+		{h.Timeout, &health.Timeout},
+		{h.StartPeriod, &health.StartPeriod},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		var err error
+		if *d.out, err = time.ParseDuration(d.value); err != nil {
+			return nil, err
+		}
+	}
+	return health, nil
+}
+
+// Names of the services, in the order they are started.
+func (c Configuration) serviceNames() []string {
+	names := make([]string, 0, len(c.Services))
+	for name := range c.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The project is named after its build container, e.g. myapp for myapp_build.
+func (c *ContainerManager) projectName() string {
+	return strings.TrimSuffix(c.containerName, "_build")
+}
+
+// Name of the docker network shared by the build container and the services.
+func (c *ContainerManager) networkName() string {
+	return c.projectName() + "_pauli"
+}
+
+// Name of the container of a service.
+func (c *ContainerManager) serviceContainerName(name string) string {
+	return c.projectName() + "_" + name
+}
+
+// Keep the services running after the tasks, StopServices then does
+// nothing. The next run reuses them.
+func WithKeepServices(keep bool) Opt {
+	return func(c *ContainerManager) error {
+		c.keepServices = keep
+		return nil
+	}
+}
+
+// Create the network of the project if it does not exist.
+func (c *ContainerManager) ensureNetwork() error {
+	name := c.networkName()
+	_, err := c.cli.NetworkInspect(c.ctx, name, types.NetworkInspectOptions{})
+	if err == nil {
+		return nil
+	}
+	if !errdefs.IsNotFound(err) {
+		return newDockerError("network inspect "+name, err)
+	}
+
+	logs.Logger.Info().Msgf("Create network %s", name)
+	_, err = c.cli.NetworkCreate(c.ctx, name, types.NetworkCreate{Driver: "bridge"})
+	if err != nil {
+		return newDockerError("network create "+name, err)
+	}
+	return nil
+}
+
+// Start the services and wait until they are healthy. A service already
+// running with the same configuration, kept by --keep-services, is reused.
+func (c *ContainerManager) startServices() error {
+	if len(c.services) == 0 {
+		return nil
+	}
+	if err := c.ensureNetwork(); err != nil {
+		return err
+	}
+
+	for _, name := range c.serviceNames {
+		if err := c.startService(name, c.services[name]); err != nil {
+			return err
+		}
+	}
+	for _, name := range c.serviceNames {
+		if err := c.waitService(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create, if needed, and start the container of a service.
+func (c *ContainerManager) startService(name string, s Service) error {
+	containerName := c.serviceContainerName(name)
+	content, _ := json.Marshal(s)
+	serviceHash := hashOf(string(content), c.networkName())
+
+	containerJSON, err := c.cli.ContainerInspect(c.ctx, containerName)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return newDockerError("inspect "+containerName, err)
+	}
+	if containerJSON.ContainerJSONBase != nil {
+		if containerJSON.Config.Labels[configHashLabel] == serviceHash {
+			return c.startContainer(containerName)
+		}
+		err = c.cli.ContainerRemove(c.ctx, containerJSON.ID,
+			types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+		if err != nil {
+			return newDockerError("remove "+containerName, err)
+		}
+	}
+
+	if _, err := c.ensureImage(s.Image, PullIfNotPresent); err != nil {
+		return err
+	}
+
+	env, err := Builder{Env: s.Env}.ResolveEnv(nil, os.LookupEnv)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", name, err)
+	}
+	// Already validated by LoadConfiguration.
+	exposedPorts, portBindings, _ := nat.ParsePortSpecs(s.Ports)
+	health, _ := s.Healthcheck.toHealthConfig()
+
+	mounts := make([]mount.Mount, len(s.Volumes))
+	for i, volume := range s.Volumes {
+		mounts[i] = volume.toMount()
+	}
+
+	conf := container.Config{
+		Image:        s.Image,
+		Env:          env,
+		ExposedPorts: exposedPorts,
+		Healthcheck:  health,
+		Labels:       map[string]string{configHashLabel: serviceHash},
+	}
+	confHost := container.HostConfig{
+		Mounts:       mounts,
+		PortBindings: portBindings,
+		NetworkMode:  container.NetworkMode(c.networkName()),
+	}
+	// The service is reachable by its name from the build container.
+	confNetwork := network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			c.networkName(): {Aliases: []string{name}},
+		},
+	}
+
+	resp, err := c.cli.ContainerCreate(c.ctx, &conf, &confHost, &confNetwork, nil, containerName)
+	if err != nil {
+		return newDockerError("create "+containerName, err)
+	}
+	logs.Logger.Info().Msgf("Service %s created with ID=%s", name, resp.ID[:10])
+	return c.startContainer(containerName)
+}
+
+// Start a container, doing nothing if it is already running.
+func (c *ContainerManager) startContainer(containerName string) error {
+	err := c.cli.ContainerStart(c.ctx, containerName, types.ContainerStartOptions{})
+	if err != nil {
+		return newDockerError("start "+containerName, err)
+	}
+	return nil
+}
+
+// Wait until a service is healthy, or running when it has no healthcheck.
+func (c *ContainerManager) waitService(name string) error {
+	containerName := c.serviceContainerName(name)
+	logged := false
+
+	for {
+		containerJSON, err := c.cli.ContainerInspect(c.ctx, containerName)
+		if err != nil {
+			return newDockerError("inspect "+containerName, err)
+		}
+
+		state := containerJSON.State
+		if !state.Running {
+			return &DockerError{
+				Op:  "service " + name,
+				Err: fmt.Errorf("exited with code %d, see docker logs %s", state.ExitCode, containerName),
+			}
+		}
+		if state.Health == nil || state.Health.Status == types.Healthy {
+			logs.Logger.Info().Msgf("Service %s is ready", name)
+			return nil
+		}
+		if state.Health.Status == types.Unhealthy {
+			return &DockerError{
+				Op:  "service " + name,
+				Err: fmt.Errorf("unhealthy, see docker logs %s", containerName),
+			}
+		}
+
+		if !logged {
+			logs.Logger.Info().Msgf("Waiting for service %s to be healthy", name)
+			logged = true
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Remove the containers of the services, unless WithKeepServices(true) was
+// passed. The network of the project is kept for the build container. It is
+// not canceled by Interrupt.
+func (c *ContainerManager) StopServices() error {
+	if c.keepServices {
+		return nil
+	}
+
+	var firstErr error
+	for _, name := range c.serviceNames {
+		containerName := c.serviceContainerName(name)
+		err := c.cli.ContainerRemove(context.Background(), containerName,
+			types.ContainerRemoveOptions{Force: true, RemoveVolumes: true})
+		if err != nil && !errdefs.IsNotFound(err) {
+			if firstErr == nil {
+				firstErr = newDockerError("remove "+containerName, err)
+			}
+			continue
+		}
+		logs.Logger.Info().Msgf("Service %s removed", name)
+	}
+	return firstErr
+}
@@ -0,0 +1,69 @@
+package src
+
+import (
+	"testing"
+	"time"
+)
+
+// Ensure services are validated before being started.
+func TestServiceValidate(t *testing.T) {
+	valid := Service{
+		Image: "postgres:16",
+		Env:   EnvVars{"POSTGRES_PASSWORD=pauli"},
+		Ports: StringList{"5432:5432"},
+		Healthcheck: &Healthcheck{
+			Test:     StringList{"pg_isready -U postgres"},
+			Interval: "2s",
+			Retries:  10,
+		},
+		Volumes: []Volume{{Type: "tmpfs", Target: "/var/lib/postgresql/data"}},
+	}
+	if err := valid.Validate("db"); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := map[string]Service{
+		"db":      {},
+		"-db":     {Image: "postgres:16"},
+		"redis":   {Image: "redis", Ports: StringList{"port"}},
+		"s3":      {Image: "minio/minio", Healthcheck: &Healthcheck{}},
+		"kafka":   {Image: "kafka", Healthcheck: &Healthcheck{Test: StringList{"true"}, Timeout: "soon"}},
+		"mongodb": {Image: "mongo", Volumes: []Volume{{Type: "volume", Source: "data"}}},
+	}
+	for name, s := range invalid {
+		if err := s.Validate(name); err == nil {
+			t.Errorf("%s %+v should be invalid", name, s)
+		}
+	}
+
+	conf := Configuration{
+		Builder:  Builder{Image: "golang", Network: "host"},
+		Services: map[string]Service{"db": valid},
+	}
+	if err := conf.Validate(); err == nil {
+		t.Error("network accepted with services.")
+	}
+}
+
+// Ensure healthchecks are converted as docker HEALTHCHECK instructions.
+func TestHealthcheckToHealthConfig(t *testing.T) {
+	cases := []struct {
+		test   StringList
+		waited []string
+	}{
+		{StringList{"redis-cli ping"}, []string{"CMD-SHELL", "redis-cli ping"}},
+		{StringList{"redis-cli", "ping"}, []string{"CMD", "redis-cli", "ping"}},
+		{StringList{"CMD", "redis-cli", "ping"}, []string{"CMD", "redis-cli", "ping"}},
+	}
+
+	for _, c := range cases {
+		health, err := (&Healthcheck{Test: c.test, Interval: "1s"}).toHealthConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(health.Test) != len(c.waited) || health.Test[0] != c.waited[0] ||
+			health.Interval != time.Second {
+			t.Errorf("Wrong healthcheck %+v. Waited: %v", health, c.waited)
+		}
+	}
+}
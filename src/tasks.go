@@ -0,0 +1,99 @@
+package src
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// A function of pauli.sh callable with pauli <name>. Its description comes
+// from the ## comment lines right above the function:
+//
+//	## Build the release binary.
+//	## Binaries are written in bin/.
+//	function build(){
+//
+// Short is the first line of the comment and Long the whole comment.
+type Task struct {
+	Name  string `json:"name"`
+	Short string `json:"short"`
+	Long  string `json:"long"`
+}
+
+// Tasks available when pauli.sh cannot be read, e.g. before pauli init.
+var DefaultTasks = []Task{
+	{Name: "build", Short: "Build the project."},
+	{Name: "run", Short: "Run the project."},
+	{Name: "clean", Short: "Remove the build artifacts."},
+	{Name: "lint", Short: "Lint the sources."},
+	{Name: "unittests", Short: "Run the unit tests."},
+	{Name: "inttests", Short: "Run the integration tests."},
+	{Name: "staticanalysis", Short: "Run the static analysis."},
+}
+
+// Functions of the pauli.sh template that are not tasks. Functions starting
+// with an underscore are private too.
+var helperFunctions = []string{"preinstall", "fatal", "highlight", "info", "warn"}
+
+// Match `function name()`, `function name {` and `name() {`.
+var functionRegexp = regexp.MustCompile(
+	`^\s*(?:function\s+([A-Za-z_][A-Za-z0-9_-]*)\s*(?:\(\s*\))?|([A-Za-z_][A-Za-z0-9_-]*)\s*\(\s*\))\s*(?:\{|$)`)
+
+// Extract the tasks defined in a pauli.sh script, in order of definition.
+func ParseTasks(reader io.Reader) ([]Task, error) {
+	var tasks []Task
+	seen := map[string]bool{}
+
+	// ## lines read since the last line that is not a ## line.
+	var comment []string
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "##") {
+			comment = append(comment, strings.TrimSpace(strings.TrimPrefix(line, "##")))
+			continue
+		}
+
+		match := functionRegexp.FindStringSubmatch(line)
+		description := comment
+		comment = nil
+		if match == nil {
+			continue
+		}
+
+		name := match[1] + match[2]
+		if seen[name] || strings.HasPrefix(name, "_") ||
+			slices.Contains(helperFunctions, name) {
+			continue
+		}
+		seen[name] = true
+
+		task := Task{Name: name}
+		if len(description) > 0 {
+			task.Short = description[0]
+			task.Long = strings.Join(description, "\n")
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, scanner.Err()
+}
+
+// Read the tasks from the pauli.sh file. DefaultTasks are returned if the
+// file does not exist.
+func LoadTasks(pauliShPath string) ([]Task, error) {
+	file, err := os.Open(pauliShPath)
+	if os.IsNotExist(err) {
+		return DefaultTasks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseTasks(file)
+}
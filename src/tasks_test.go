@@ -0,0 +1,73 @@
+package src
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Ensure every function definition style is found and helpers are skipped.
+func TestParseTasks(t *testing.T) {
+	script := `#!/bin/sh
+## Build the release binary.
+## Binaries are written in bin/.
+function build(){
+    echo "build"
+}
+
+function codegen {
+    echo "codegen"
+}
+
+migrate() {
+    echo "migrate"
+}
+
+release ()
+{
+    echo "release"
+}
+
+function _private(){
+    echo "private"
+}
+
+function info(){
+    echo -e "\e[92m $1 \e[0m"
+}
+
+# function commented(){
+build
+`
+	tasks, err := ParseTasks(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, task := range tasks {
+		names = append(names, task.Name)
+	}
+
+	waited := []string{"build", "codegen", "migrate", "release"}
+	if !slices.Equal(names, waited) {
+		t.Fatalf("Wrong tasks %v. Waited: %v", names, waited)
+	}
+
+	// Check descriptions.
+	if tasks[0].Short != "Build the release binary." ||
+		tasks[0].Long != "Build the release binary.\nBinaries are written in bin/." {
+		t.Fatalf("Wrong description %q, %q", tasks[0].Short, tasks[0].Long)
+	}
+	if tasks[1].Short != "" || tasks[1].Long != "" {
+		t.Fatalf("codegen has no description, got %q", tasks[1].Long)
+	}
+}
+
+// Ensure the default tasks are used when pauli.sh does not exist.
+func TestLoadTasksDefault(t *testing.T) {
+	tasks, err := LoadTasks("/tmp/does_not_exist.sh")
+	if err != nil || !slices.Equal(tasks, DefaultTasks) {
+		t.Fatalf("Wrong tasks %v, %v. Waited: %v", tasks, err, DefaultTasks)
+	}
+}
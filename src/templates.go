@@ -0,0 +1,159 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A language template of pauli init. It pre-fills config.yaml with a builder
+// image and pauli.sh with function bodies.
+type ProjectTemplate struct {
+	Image string
+	Tag   string
+	// Body of the pauli.sh functions, indexed by function name. Functions
+	// without body keep the "not implemented" body of the generic template.
+	Functions map[string]string
+}
+
+var ProjectTemplates = map[string]ProjectTemplate{
+	"generic": {},
+	"go": {
+		Image: "golang",
+		Tag:   "1.22",
+		Functions: map[string]string{
+			"build":          `go build -o bin/ "$@" ./...`,
+			"run":            `go run . "$@"`,
+			"clean":          `rm -rf bin/`,
+			"lint":           `test -z "$(gofmt -l .)" || { gofmt -l .; exit 1; }`,
+			"unittests":      `go test "$@" ./...`,
+			"inttests":       `go test -tags integration "$@" ./...`,
+			"staticanalysis": `go vet "$@" ./...`,
+		},
+	},
+	"python": {
+		Image: "python",
+		Tag:   "3.12",
+		Functions: map[string]string{
+			"build":          `pip install --quiet -e . && pip wheel --no-deps -w dist/ "$@" .`,
+			"clean":          `rm -rf build/ dist/ *.egg-info`,
+			"lint":           `python -m flake8 "$@" .`,
+			"unittests":      `python -m pytest tests/unit "$@"`,
+			"inttests":       `python -m pytest tests/integration "$@"`,
+			"staticanalysis": `python -m mypy "$@" .`,
+		},
+	},
+	"node": {
+		Image: "node",
+		Tag:   "20",
+		Functions: map[string]string{
+			"build":          `npm ci --silent && npm run build -- "$@"`,
+			"run":            `npm start -- "$@"`,
+			"clean":          `rm -rf dist/`,
+			"lint":           `npm run lint -- "$@"`,
+			"unittests":      `npm test -- "$@"`,
+			"inttests":       `npm run test:integration -- "$@"`,
+			"staticanalysis": `npm audit "$@"`,
+		},
+	},
+	"rust": {
+		Image: "rust",
+		Tag:   "1.77",
+		Functions: map[string]string{
+			"build":          `cargo build --release "$@"`,
+			"run":            `cargo run -- "$@"`,
+			"clean":          `cargo clean`,
+			"lint":           `cargo fmt --check "$@"`,
+			"unittests":      `cargo test --lib "$@"`,
+			"inttests":       `cargo test --tests "$@"`,
+			"staticanalysis": `cargo clippy "$@" -- -D warnings`,
+		},
+	},
+	"java": {
+		Image: "maven",
+		Tag:   "3-eclipse-temurin-21",
+		Functions: map[string]string{
+			"build":          `mvn -B package -DskipTests "$@"`,
+			"run":            `mvn -B exec:java "$@"`,
+			"clean":          `mvn -B clean`,
+			"lint":           `mvn -B checkstyle:check "$@"`,
+			"unittests":      `mvn -B test "$@"`,
+			"inttests":       `mvn -B verify "$@"`,
+			"staticanalysis": `mvn -B spotbugs:check "$@"`,
+		},
+	},
+}
+
+// A file identifying the type of a project.
+type projectMarker struct {
+	file     string
+	template string
+	version  *regexp.Regexp // Capture the language version from the file.
+	tag      string         // Image tag, %s is replaced by the version.
+}
+
+// Checked in order, the first marker found wins.
+var projectMarkers = []projectMarker{
+	{"go.mod", "go", regexp.MustCompile(`(?m)^go\s+(\d+\.\d+)`), "%s"},
+	{"Cargo.toml", "rust", regexp.MustCompile(`rust-version\s*=\s*"(\d+\.\d+)`), "%s"},
+	{"pyproject.toml", "python", regexp.MustCompile(`requires-python\s*=\s*"[^"\d]*(\d+\.\d+)`), "%s"},
+	{"package.json", "node", regexp.MustCompile(`"node"\s*:\s*"[^"\d]*(\d+)`), "%s"},
+	{"pom.xml", "java", regexp.MustCompile(
+		`<(?:maven\.compiler\.release|maven\.compiler\.source|java\.version)>(?:1\.)?(\d+)<`),
+		"3-eclipse-temurin-%s"},
+}
+
+// Detect the type of the project in dir from its files (go.mod,
+// package.json...). Return the name of the matching template, with the tag
+// of the image set to the language version of the project when the project
+// declares it.
+func DetectProject(dir string) (string, ProjectTemplate, bool) {
+	for _, marker := range projectMarkers {
+		content, err := os.ReadFile(filepath.Join(dir, marker.file))
+		if err != nil {
+			continue
+		}
+
+		t := ProjectTemplates[marker.template]
+		if match := marker.version.FindSubmatch(content); match != nil {
+			t.Tag = fmt.Sprintf(marker.tag, match[1])
+		}
+		return marker.template, t, true
+	}
+	return "", ProjectTemplate{}, false
+}
+
+// Return the template named name.
+func GetProjectTemplate(name string) (ProjectTemplate, error) {
+	t, ok := ProjectTemplates[name]
+	if !ok {
+		return t, fmt.Errorf("unknown template %s, waited one of %v",
+			name, ProjectTemplateNames())
+	}
+	return t, nil
+}
+
+// Sorted names of the templates.
+func ProjectTemplateNames() []string {
+	names := make([]string, 0, len(ProjectTemplates))
+	for name := range ProjectTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Replace the "not implemented" body of the pauli.sh functions with the
+// bodies of the template.
+func (t ProjectTemplate) Render(script []byte) []byte {
+	s := string(script)
+	for name, body := range t.Functions {
+		s = strings.Replace(s,
+			fmt.Sprintf("    echo \"%s not implemented.\"", name),
+			"    "+body, 1)
+	}
+	return []byte(s)
+}
@@ -0,0 +1,64 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Ensure the project type and the language version are detected.
+func TestDetectProject(t *testing.T) {
+	cases := []struct {
+		file, content, template, image, tag string
+	}{
+		{"go.mod", "module x\n\ngo 1.21.1\n", "go", "golang", "1.21"},
+		{"package.json", `{"engines": {"node": ">=18.0.0"}}`, "node", "node", "18"},
+		{"pyproject.toml", "[project]\nrequires-python = \">=3.11\"\n", "python", "python", "3.11"},
+		{"Cargo.toml", "[package]\nrust-version = \"1.70\"\n", "rust", "rust", "1.70"},
+		{"pom.xml", "<properties><java.version>17</java.version></properties>", "java", "maven", "3-eclipse-temurin-17"},
+		// Without version, keep the tag of the template.
+		{"Cargo.toml", "[package]\nname = \"x\"\n", "rust", "rust", ProjectTemplates["rust"].Tag},
+	}
+
+	for _, c := range cases {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, c.file), []byte(c.content), 0644)
+
+		name, projectTemplate, ok := DetectProject(dir)
+		if !ok || name != c.template ||
+			projectTemplate.Image != c.image || projectTemplate.Tag != c.tag {
+			t.Errorf("%s: detected %s %s:%s. Waited: %s %s:%s", c.file,
+				name, projectTemplate.Image, projectTemplate.Tag, c.template, c.image, c.tag)
+		}
+	}
+
+	if _, _, ok := DetectProject(t.TempDir()); ok {
+		t.Error("Project detected in an empty folder.")
+	}
+}
+
+// Ensure template bodies replace the generic ones.
+func TestProjectTemplateRender(t *testing.T) {
+	script := "function build(){\n    echo \"build not implemented.\"\n}\n" +
+		"function run(){\n    echo \"run not implemented.\"\n}\n" +
+		"function preinstall(){\n    echo \"preinstall not implemented.\"\n}\n"
+
+	rendered := string(ProjectTemplate{Functions: map[string]string{"build": "make"}}.Render([]byte(script)))
+
+	if !strings.Contains(rendered, "    make\n") || !strings.Contains(rendered, "run not implemented.") {
+		t.Fatalf("Wrong rendering:\n%s", rendered)
+	}
+	// The module to run depends on the project, it is left to the user.
+	rendered = string(ProjectTemplates["python"].Render([]byte(script)))
+	if !strings.Contains(rendered, "run not implemented.") {
+		t.Fatalf("Wrong python rendering:\n%s", rendered)
+	}
+	// preinstall runs before every task, installing dependencies is left to build.
+	for _, name := range []string{"python", "node"} {
+		rendered = string(ProjectTemplates[name].Render([]byte(script)))
+		if !strings.Contains(rendered, "preinstall not implemented.") {
+			t.Errorf("Wrong %s rendering:\n%s", name, rendered)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package src
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/mercierc/pauli/logs"
+)
+
+// A condition pauli waits for before executing a task. Exactly one of TCP,
+// HTTP, File and Command is set. Service names are valid hosts of TCP and
+// HTTP.
+type WaitCondition struct {
+	TCP     string `yaml:"tcp"`     // host:port accepting connections.
+	HTTP    string `yaml:"http"`    // URL answering with Status.
+	Status  int    `yaml:"status"`  // 200 by default.
+	File    string `yaml:"file"`    // Path existing in the build container.
+	Command string `yaml:"command"` // Shell command exiting with 0 in the build container.
+	Timeout string `yaml:"timeout"` // 60s by default.
+}
+
+const (
+	defaultWaitTimeout = 60 * time.Second
+	waitInterval       = time.Second
+)
+
+func (w WaitCondition) String() string {
+	switch {
+	case w.TCP != "":
+		return "tcp " + w.TCP
+	case w.HTTP != "":
+		return fmt.Sprintf("http %s with status %d", w.HTTP, w.status())
+	case w.File != "":
+		return "file " + w.File
+	default:
+		return "command " + w.Command
+	}
+}
+
+// Ensure the condition is well formed.
+func (w WaitCondition) Validate() error {
+	kinds := 0
+	for _, value := range []string{w.TCP, w.HTTP, w.File, w.Command} {
+		if value != "" {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("wait_for: exactly one of tcp, http, file or command is required")
+	}
+
+	if w.TCP != "" {
+		if _, _, err := net.SplitHostPort(w.TCP); err != nil {
+			return fmt.Errorf("wait_for: tcp: %w", err)
+		}
+	}
+	if w.HTTP != "" {
+		u, err := url.Parse(w.HTTP)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("wait_for: invalid http URL %s", w.HTTP)
+		}
+	}
+	if w.Status != 0 && w.HTTP == "" {
+		return fmt.Errorf("wait_for: status is only allowed with http")
+	}
+	if w.Timeout != "" {
+		if _, err := time.ParseDuration(w.Timeout); err != nil {
+			return fmt.Errorf("wait_for: timeout: %w", err)
+		}
+	}
+	return nil
+}
+
+func (w WaitCondition) status() int {
+	if w.Status == 0 {
+		return http.StatusOK
+	}
+	return w.Status
+}
+
+func (w WaitCondition) timeout() time.Duration {
+	// Already validated by LoadConfiguration.
+	if timeout, err := time.ParseDuration(w.Timeout); err == nil {
+		return timeout
+	}
+	return defaultWaitTimeout
+}
+
+// Wait for the conditions of wait_for, in order.
+func (c *ContainerManager) waitForConditions() error {
+	for _, w := range c.waitFor {
+		if err := c.waitForCondition(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check a condition every second until it is met or times out.
+func (c *ContainerManager) waitForCondition(w WaitCondition) error {
+	deadline := time.Now().Add(w.timeout())
+
+	for pending := false; ; pending = true {
+		err := c.check(w)
+		if err == nil {
+			if pending {
+				logs.Logger.Info().Msgf("%s is ready", w)
+			}
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("wait_for %s: not ready after %s: %w", w, w.timeout(), err)
+		}
+		if !pending {
+			logs.Logger.Info().Msgf("Waiting for %s (timeout %s): %v", w, w.timeout(), err)
+		} else {
+			logs.Logger.Debug().Msgf("%s is pending: %v", w, err)
+		}
+		time.Sleep(waitInterval)
+	}
+}
+
+// Probes of the tcp and http conditions, run by /bin/sh in the build
+// container so that services, extra_hosts and localhost resolve as in the
+// tasks. The tcp probe uses nc, bash or python3, the http probe curl, wget
+// or python3, the first found, and both exit with 127 when there is none.
+const (
+	tcpProbe = `host=$1 port=$2
+if command -v nc >/dev/null 2>&1; then exec nc -z -w 2 "$host" "$port"; fi
+if command -v bash >/dev/null 2>&1; then
+  command -v timeout >/dev/null 2>&1 && limit="timeout 2"
+  exec $limit bash -c 'exec 3<>"/dev/tcp/$0/$1"' "$host" "$port" 2>/dev/null
+fi
+if command -v python3 >/dev/null 2>&1; then
+  exec python3 -c 'import socket, sys; socket.create_connection((sys.argv[1], int(sys.argv[2])), 2)' "$host" "$port" 2>/dev/null
+fi
+exit 127`
+
+	httpProbe = `url=$1 status=$2
+if command -v curl >/dev/null 2>&1; then
+  code=$(curl -s -k -o /dev/null -m 2 -w '%{http_code}' "$url")
+elif command -v wget >/dev/null 2>&1; then
+  code=$(wget -S -O /dev/null -T 2 "$url" 2>&1 | awk '/HTTP\/[0-9.]+ [0-9]+/ { c = $2 } END { print c }')
+elif command -v python3 >/dev/null 2>&1; then
+  code=$(python3 -c 'import sys, urllib.request, urllib.error
+try:
+    print(urllib.request.urlopen(sys.argv[1], timeout=2).status)
+except urllib.error.HTTPError as e:
+    print(e.code)' "$url" 2>/dev/null)
+else
+  exit 127
+fi
+[ "$code" = "$status" ]`
+)
+
+// Return nil when the condition is met, why it is not otherwise.
+func (c *ContainerManager) check(w WaitCondition) error {
+	switch {
+	case w.TCP != "":
+		host, port, _ := net.SplitHostPort(w.TCP)
+		return c.checkProbe(tcpProbe, host, port)
+
+	case w.HTTP != "":
+		return c.checkProbe(httpProbe, w.HTTP, strconv.Itoa(w.status()))
+
+	case w.File != "":
+		return c.checkCommand([]string{"test", "-e", w.File})
+
+	default:
+		return c.checkCommand([]string{"/bin/sh", "-c", w.Command})
+	}
+}
+
+// Run a probe script with args in the build container.
+func (c *ContainerManager) checkProbe(probe string, args ...string) error {
+	exitCode, err := c.execQuiet(c.checkConfig(append([]string{"/bin/sh", "-c", probe, "sh"}, args...)))
+	if err != nil {
+		return err
+	}
+	switch exitCode {
+	case 0:
+		return nil
+	case 127:
+		return fmt.Errorf("no nc, curl, wget, bash or python3 in the build container to check it")
+	default:
+		return fmt.Errorf("exit code %d", exitCode)
+	}
+}
+
+// Execute cmd as the tasks are executed and return an error if it fails.
+func (c *ContainerManager) checkCommand(cmd []string) error {
+	exitCode, err := c.execQuiet(c.checkConfig(cmd))
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("exit code %d", exitCode)
+	}
+	return nil
+}
+
+// Execute cmd with the environment, workdir and user of the tasks.
+func (c *ContainerManager) checkConfig(cmd []string) types.ExecConfig {
+	return types.ExecConfig{
+		Cmd:        cmd,
+		Env:        c.env,
+		WorkingDir: c.workspace.Workdir,
+		User:       c.user,
+	}
+}
@@ -0,0 +1,125 @@
+package src
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Ensure malformed conditions are refused.
+func TestWaitConditionValidate(t *testing.T) {
+	valid := []WaitCondition{
+		{TCP: "db:5432"},
+		{HTTP: "http://localhost:8080/health", Status: 204, Timeout: "2m"},
+		{File: "/tmp/ready"},
+		{Command: "pg_isready -h db"},
+	}
+	invalid := []WaitCondition{
+		{},
+		{TCP: "db:5432", File: "/tmp/ready"},
+		{TCP: "db"},
+		{HTTP: "localhost:8080"},
+		{File: "/tmp/ready", Status: 200},
+		{Command: "true", Timeout: "forever"},
+	}
+
+	for _, w := range valid {
+		if err := w.Validate(); err != nil {
+			t.Errorf("%+v should be valid: %v", w, err)
+		}
+	}
+	for _, w := range invalid {
+		if err := w.Validate(); err == nil {
+			t.Errorf("%+v should be invalid", w)
+		}
+	}
+}
+
+// Run probe with only the tools in PATH, as in a minimal build container.
+func runProbe(t *testing.T, tools []string, probe string, args ...string) int {
+	bin := t.TempDir()
+	for _, tool := range tools {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+		if tool == "python3" {
+			// Skip the shims of pyenv and the like.
+			out, err := exec.Command(path, "-c", "import sys; print(sys.executable)").Output()
+			if err != nil {
+				t.Skip(err)
+			}
+			path = strings.TrimSpace(string(out))
+		}
+		os.Symlink(path, filepath.Join(bin, tool))
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	cmd := exec.Command(sh, append([]string{"-c", probe, "sh"}, args...)...)
+	cmd.Env = []string{"PATH=" + bin}
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0
+}
+
+// Ensure the tcp probe detects an open port with each of its tools.
+func TestTCPProbe(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+	defer listener.Close()
+
+	for _, tools := range [][]string{{"nc"}, {"bash", "timeout"}, {"python3"}} {
+		t.Run(tools[0], func(t *testing.T) {
+			if code := runProbe(t, tools, tcpProbe, host, port); code != 0 {
+				t.Errorf("exit code %d on an open port.", code)
+			}
+			if code := runProbe(t, tools, tcpProbe, host, closedPort); code == 0 {
+				t.Error("closed port reported open.")
+			}
+		})
+	}
+
+	if code := runProbe(t, nil, tcpProbe, host, port); code != 127 {
+		t.Errorf("exit code %d without any tool, waited 127.", code)
+	}
+}
+
+// Ensure the http probe compares the status with each of its tools.
+func TestHTTPProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	for _, tools := range [][]string{{"curl"}, {"wget", "awk"}, {"python3"}} {
+		t.Run(tools[0], func(t *testing.T) {
+			if code := runProbe(t, tools, httpProbe, server.URL, "202"); code != 0 {
+				t.Errorf("exit code %d on the waited status.", code)
+			}
+			if code := runProbe(t, tools, httpProbe, server.URL, "200"); code == 0 {
+				t.Error("wrong status accepted.")
+			}
+		})
+	}
+
+	if code := runProbe(t, nil, httpProbe, server.URL, "202"); code != 127 {
+		t.Errorf("exit code %d without any tool, waited 127.", code)
+	}
+}